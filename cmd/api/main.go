@@ -1,14 +1,31 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"log"
 	"net/http"
 
+	"github.com/SubhanAfz/scraper/cmd/internal/ruleflags"
 	"github.com/SubhanAfz/scraper/pkg/browser"
 	"github.com/SubhanAfz/scraper/pkg/server"
 )
 
 func main() {
-	ChromeService, err := browser.NewChrome()
+	poolSize := flag.Int("pool-size", browser.DefaultPoolSize, "maximum number of pages scraped concurrently")
+	var ruleFlags ruleflags.Flags
+	ruleFlags.Bind(flag.CommandLine)
+	flag.Parse()
+
+	rules, err := ruleFlags.Open(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ChromeService, err := browser.NewChrome(browser.ChromeConfig{
+		PoolSize: *poolSize,
+		Rules:    rules,
+	})
 	if err != nil {
 		panic(err)
 	}
@@ -16,10 +33,14 @@ func main() {
 
 	server := &server.Server{
 		BrowserService: ChromeService,
+		Rules:          rules,
 	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /get_page", server.GetPageHandler)
 	mux.HandleFunc("GET /screenshot", server.ScreenShotHandler)
+	mux.HandleFunc("GET /pdf", server.PDFHandler)
+	mux.HandleFunc("GET /rules", server.RulesHandler)
+	mux.HandleFunc("GET /debug/consent", server.DebugConsentHandler)
 	http.ListenAndServe(":8080", mux)
 }
@@ -0,0 +1,100 @@
+package ruleflags
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/SubhanAfz/scraper/pkg/autoconsent"
+)
+
+// defaultRulesPoll is how often the rule files are checked for changes unless -rules-poll says otherwise.
+const defaultRulesPoll = 5 * time.Second
+
+/*
+Flags holds the command line flags that set up the consent rules.
+
+	Config: the rule files, from -rules, -extra-rules and -disable-rules
+	Poll: how often the rule files are checked for changes, from -rules-poll, 0 disables it
+	Evals: a JSON file of extra eval snippets keyed by id, from -evals
+*/
+type Flags struct {
+	Config autoconsent.StoreConfig
+	Poll   time.Duration
+	Evals  string
+}
+
+// Bind defines the consent rule flags on fs, which store their values in f when fs is parsed.
+func (f *Flags) Bind(fs *flag.FlagSet) {
+	fs.StringVar(&f.Config.Path, "rules", "", "consent rule file to use instead of the embedded rules")
+	fs.Var((*listFlag)(&f.Config.Extra), "extra-rules", "comma separated consent rule `files` whose rules take precedence over the upstream rules")
+	fs.Var((*listFlag)(&f.Config.Disabled), "disable-rules", "comma separated `names` of upstream consent rules not to use")
+	fs.DurationVar(&f.Poll, "rules-poll", defaultRulesPoll, "how often to check the rule files for changes, 0 to disable")
+	fs.StringVar(&f.Evals, "evals", "", "JSON file of extra eval snippets for the consent rules, keyed by id")
+}
+
+// Open registers the extra eval snippets and loads the rules the flags select. The rules are then
+// reloaded whenever a rule file changes or the process receives SIGHUP, until ctx is cancelled.
+func (f *Flags) Open(ctx context.Context) (*autoconsent.Store, error) {
+	if f.Evals != "" {
+		if err := autoconsent.LoadEvals(f.Evals); err != nil {
+			return nil, err
+		}
+	}
+	store, err := autoconsent.NewStore(f.Config)
+	if err != nil {
+		return nil, err
+	}
+	if f.Poll > 0 {
+		go store.Watch(ctx, f.Poll)
+	}
+	reloadOnSignal(ctx, store, syscall.SIGHUP)
+	return store, nil
+}
+
+// reloadOnSignal reloads store whenever the process receives one of sigs, until ctx is cancelled.
+// It returns once the signals are being listened for, so none sent afterwards is missed.
+func reloadOnSignal(ctx context.Context, store *autoconsent.Store, sigs ...os.Signal) {
+	received := make(chan os.Signal, 1)
+	signal.Notify(received, sigs...)
+	go func() {
+		defer signal.Stop(received)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-received:
+				if err := store.Reload(); err != nil {
+					log.Printf("autoconsent: reloading on %v: %v", sig, err)
+					continue
+				}
+				log.Printf("autoconsent: reloaded on %v", sig)
+			}
+		}
+	}()
+}
+
+// listFlag is a comma separated flag value, with empty entries dropped.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package ruleflags
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/SubhanAfz/scraper/pkg/autoconsent"
+)
+
+func TestFlags(t *testing.T) {
+	var f Flags
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	f.Bind(fs)
+	err := fs.Parse([]string{"-rules", "rules.json", "-extra-rules", " a.json, ,b.json", "-disable-rules", "x,y", "-rules-poll", "0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := autoconsent.StoreConfig{Path: "rules.json", Extra: []string{"a.json", "b.json"}, Disabled: []string{"x", "y"}}
+	if f.Config.Path != want.Path || !slices.Equal(f.Config.Extra, want.Extra) || !slices.Equal(f.Config.Disabled, want.Disabled) {
+		t.Errorf("Config = %+v, want %+v", f.Config, want)
+	}
+	if f.Poll != 0 {
+		t.Errorf("Poll = %s, want 0", f.Poll)
+	}
+
+	var defaults Flags
+	defaults.Bind(flag.NewFlagSet("defaults", flag.ContinueOnError))
+	if defaults.Poll != defaultRulesPoll {
+		t.Errorf("default Poll = %s, want %s", defaults.Poll, defaultRulesPoll)
+	}
+}
+
+func TestReloadOnSignal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "extra.json")
+	write := func(name string) {
+		if err := os.WriteFile(path, []byte(`{"autoconsent": [{"name": "`+name+`"}]}`), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("before")
+	store, err := autoconsent.NewStore(autoconsent.StoreConfig{Extra: []string{path}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloadOnSignal(ctx, store, syscall.SIGHUP)
+
+	write("after")
+	self, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := self.Signal(syscall.SIGHUP); err != nil {
+		t.Skipf("can't send SIGHUP: %v", err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if slices.Equal(store.Rules().Sources()[0].Rules, []string{"after"}) {
+			return
+		}
+	}
+	t.Errorf("rules after SIGHUP = %v, want [after]", store.Rules().Sources()[0].Rules)
+}
@@ -1,25 +1,43 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
 	"net/http"
 
+	"github.com/SubhanAfz/scraper/cmd/internal/ruleflags"
 	"github.com/SubhanAfz/scraper/pkg/browser"
 	"github.com/SubhanAfz/scraper/pkg/server"
 	"github.com/modelcontextprotocol/go-sdk/mcp"
 )
 
 func main() {
-	ChromeService, err := browser.NewChrome()
+	poolSize := flag.Int("pool-size", browser.DefaultPoolSize, "maximum number of pages scraped concurrently")
+	var ruleFlags ruleflags.Flags
+	ruleFlags.Bind(flag.CommandLine)
+	flag.Parse()
+
+	rules, err := ruleFlags.Open(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ChromeService, err := browser.NewChrome(browser.ChromeConfig{
+		PoolSize: *poolSize,
+		Rules:    rules,
+	})
 	if err != nil {
 		panic(err)
 	}
 	defer ChromeService.Close()
 	s := &server.Server{
 		BrowserService: ChromeService,
+		Rules:          rules,
 	}
 	server := mcp.NewServer(&mcp.Implementation{Name: "web_scraper", Version: "v1.0.0"}, nil)
 	mcp.AddTool(server, &mcp.Tool{Name: "get_page", Description: "Fetch a web page"}, s.GetPageMCPHandler)
+	mcp.AddTool(server, &mcp.Tool{Name: "debug_consent", Description: "Explain which cookie consent rule matches a web page and why, with screenshots before and after consent handling"}, s.DebugConsentMCPHandler)
 	handler := mcp.NewStreamableHTTPHandler(func(r *http.Request) *mcp.Server {
 		return server
 	}, nil)
@@ -5,39 +5,125 @@ import (
 	"encoding/json"
 	"fmt"
 	"regexp"
-	"strings"
 	"time"
-
-	"github.com/chromedp/chromedp"
 )
 
 type AutoConsentRules struct {
-	Rules []AutoConsentRule `json:"autoconsent"`
+	Rules   []AutoConsentRule `json:"autoconsent"`
+	index   ruleIndex
+	sources []RuleSource
 }
 
 type AutoConsentRule struct {
-	Name        string     `json:"name"`
-	DetectCMP   ActionList `json:"detectCMP"`
-	DetectPopup ActionList `json:"detectPopup"`
-	OptIn       ActionList `json:"optIn"`
-	OptOut      ActionList `json:"optOut"`
-	RunContext  RunContext `json:"runContext"`
+	Name             string     `json:"name"`
+	PrehideSelectors []string   `json:"prehideSelectors"` // CSS selectors hidden from page start until the CMP is handled
+	Cosmetic         bool       `json:"cosmetic"`         // the opt-out only hides the banner rather than answering it
+	DetectCMP        ActionList `json:"detectCMP"`
+	DetectPopup      ActionList `json:"detectPopup"`
+	OptIn            ActionList `json:"optIn"`
+	OptOut           ActionList `json:"optOut"`
+	Test             ActionList `json:"test"` // checks that the opt-out took effect, such as the consent cookie it leaves behind
+	RunContext       RunContext `json:"runContext"`
+}
+
+// PopupSelectors returns the elements the rule looks for to tell its banner is there: the selectors of
+// the exists, visible and waitFor steps of detectPopup, then of detectCmp, then the prehide selectors.
+// Negated steps and visibility checks for none look for something being absent and are skipped.
+func (r AutoConsentRule) PopupSelectors() []ElementSelector {
+	var selectors []ElementSelector
+	seen := map[string]bool{}
+	add := func(selector ElementSelector) {
+		key := fmt.Sprint(selector.Element) + "\x00" + selector.Text
+		if !seen[key] {
+			seen[key] = true
+			selectors = append(selectors, selector)
+		}
+	}
+	for _, list := range []ActionList{r.DetectPopup, r.DetectCMP} {
+		walkActions(list, func(action Action) {
+			if action.Modifiers().Negated {
+				return
+			}
+			switch a := action.(type) {
+			case ExistsAction:
+				add(a.Exists)
+			case VisibleAction:
+				if a.Check != CheckNone {
+					add(a.Visible)
+				}
+			case WaitForAction:
+				add(a.WaitFor)
+			case WaitForVisibleAction:
+				if a.Check != CheckNone {
+					add(a.WaitFor)
+				}
+			}
+		})
+	}
+	for _, selector := range r.PrehideSelectors {
+		add(ElementSelector{Element: selector})
+	}
+	return selectors
+}
+
+// HideSteps returns the hide steps of the rule's opt-out, including those nested in if and any steps,
+// so a banner can be hidden the way the rule does without clicking or running anything else.
+func (r AutoConsentRule) HideSteps() ActionList {
+	var steps ActionList
+	walkActions(r.OptOut, func(action Action) {
+		if hide, ok := action.(HideAction); ok {
+			steps = append(steps, hide)
+		}
+	})
+	return steps
 }
 
 type RunContext struct {
+	Main       *bool  `json:"main"`  // run in the top level document, true when unset
+	Frame      *bool  `json:"frame"` // run in child frames, false when unset
 	UrlPattern string `json:"urlPattern"`
+	pattern    *regexp.Regexp
+}
+
+// UnmarshalJSON compiles the urlPattern once, when the rule is loaded.
+func (r *RunContext) UnmarshalJSON(data []byte) error {
+	type plain RunContext
+	if err := json.Unmarshal(data, (*plain)(r)); err != nil {
+		return err
+	}
+	if r.UrlPattern == "" {
+		return nil
+	}
+	pattern, err := regexp.Compile(r.UrlPattern)
+	if err != nil {
+		return fmt.Errorf("invalid urlPattern %q: %w", r.UrlPattern, err)
+	}
+	r.pattern = pattern
+	return nil
+}
+
+func (r *RunContext) RunsInMain() bool {
+	return r.Main == nil || *r.Main
+}
+
+func (r *RunContext) RunsInFrame() bool {
+	return r.Frame != nil && *r.Frame
 }
 
 func (r *RunContext) URLMatches(url string) bool {
 	if r.UrlPattern == "" {
 		return true // No pattern means it matches all URLs
 	}
-	re := regexp.MustCompile(r.UrlPattern)
+	re := r.pattern
+	if re == nil {
+		re = regexp.MustCompile(r.UrlPattern)
+	}
 	return re.MatchString(url)
 }
 
 type ElementSelector struct {
 	Element interface{}
+	Text    string // when set, only elements whose text contains it, or matches it when written as a /regex/flags literal, match; taken from the step's text field
 }
 
 func (e *ElementSelector) UnmarshalJSON(data []byte) error {
@@ -56,230 +142,29 @@ func (e *ElementSelector) UnmarshalJSON(data []byte) error {
 	return fmt.Errorf("ElementSelector must be string or []string")
 }
 
-func (e *ElementSelector) ElementExists(ctx context.Context) (bool, error) {
-	switch s := e.Element.(type) {
-	case string:
-		if strings.HasPrefix(s, "xpath/") {
-			return elementExistsXPath(ctx, s[6:])
-		}
-		return elementExists(ctx, s)
-	case []string:
-		return elementExistsComplex(ctx, s)
-	default:
-		return false, fmt.Errorf("unsupported selector type: %T", e.Element)
-	}
-}
-
-func (e *ElementSelector) Click(ctx context.Context) error {
-	switch s := e.Element.(type) {
-	case string:
-		if strings.HasPrefix(s, "xpath/") {
-			return clickElementRobust(ctx, s[6:], true)
-		}
-		return clickElementRobust(ctx, s, false)
-	case []string:
-		return clickComplex(ctx, s)
-	default:
-		return fmt.Errorf("unsupported selector type for click: %T", e.Element)
-	}
-}
-
-func clickElementRobust(ctx context.Context, selector string, isXPath bool) error {
-	// First, try to ensure element is visible and clickable
-	var tasks []chromedp.Action
-
-	if isXPath {
-		// For XPath, first scroll element into view
-		tasks = append(tasks,
-			chromedp.ScrollIntoView(selector, chromedp.BySearch),
-			chromedp.Sleep(100*time.Millisecond),
-		)
-
-		// Strategy 2: JavaScript click via XPath
-		js := fmt.Sprintf(`
-			(function() {
-				const element = document.evaluate(%s, document, null, XPathResult.FIRST_ORDERED_NODE_TYPE, null).singleNodeValue;
-				if (element) {
-					element.scrollIntoView({behavior: 'instant', block: 'center'});
-					element.click();
-					return true;
-				}
-				return false;
-			})()`, jsonEscape(selector))
-
-		var success bool
-		err := chromedp.Run(ctx, chromedp.Evaluate(js, &success))
-		if err == nil && success {
-			return nil
-		}
-
-		return fmt.Errorf("failed to click XPath element: %v", err)
-	} else {
-		// For CSS selectors, scroll into view first
-		tasks = append(tasks,
-			chromedp.ScrollIntoView(selector),
-			chromedp.Sleep(100*time.Millisecond),
-		)
-
-		js := fmt.Sprintf(`
-			(function() {
-				const element = document.querySelector(%s);
-				if (element) {
-					element.scrollIntoView({behavior: 'instant', block: 'center'});
-					element.click();
-					return true;
-				}
-				return false;
-			})()`, jsonEscape(selector))
-
-		var success bool
-		err := chromedp.Run(ctx, chromedp.Evaluate(js, &success))
-		if err == nil && success {
-			return nil
-		}
-		return fmt.Errorf("failed to click CSS element: %v", err)
-	}
-}
-
-func clickComplex(ctx context.Context, selectors []string) error {
-	// Build JavaScript to handle shadow DOM and iframe piercing for clicking
-	selectorsJSON, err := json.Marshal(selectors)
-	if err != nil {
-		return err
-	}
-
-	js := fmt.Sprintf(`
-        (function() {
-            function clickElement(selectors) {
-                let element = document;
-                
-                for (let i = 0; i < selectors.length; i++) {
-                    const selector = selectors[i];
-                    
-                    if (selector.startsWith('xpath/')) {
-                        // Handle XPath - evaluate against current context
-                        const xpath = selector.substring(6);
-                        const contextNode = element.nodeType === Node.DOCUMENT_NODE || element.nodeType === Node.DOCUMENT_FRAGMENT_NODE ? element : element.ownerDocument || document;
-                        const result = contextNode.evaluate(xpath, element, null, XPathResult.FIRST_ORDERED_NODE_TYPE, null);
-                        element = result.singleNodeValue;
-                    } else {
-                        // Handle CSS selector
-                        element = element.querySelector(selector);
-                    }
-                    
-                    if (!element) return false;
-                    
-                    // If this is the last selector, click the element
-                    if (i === selectors.length - 1) {
-                        // Scroll into view and click
-                        element.scrollIntoView({behavior: 'instant', block: 'center'});
-                        element.click();
-                        return true;
-                    }
-                    
-                    // Pierce shadow DOM if available
-                    if (element.shadowRoot) {
-                        element = element.shadowRoot;
-                    }
-                    // Pierce iframe if available and same-origin
-                    else if (element.tagName === 'IFRAME' && element.contentDocument) {
-                        element = element.contentDocument;
-                    }
-                }
-                
-                return false;
-            }
-            
-            return clickElement(%s);
-        })()`, string(selectorsJSON))
-
-	var success bool
-	err = chromedp.Run(ctx, chromedp.Evaluate(js, &success))
-	if err != nil {
-		return err
-	}
-	if !success {
-		return fmt.Errorf("failed to click element with complex selector")
-	}
-	return nil
-}
-
-func elementExists(ctx context.Context, selector string) (bool, error) {
-	var exists bool
-	js := fmt.Sprintf(`document.querySelector(%s) !== null`, jsonEscape(selector))
-	err := chromedp.Run(ctx, chromedp.Evaluate(js, &exists))
-	return exists, err
-}
-
-func elementExistsXPath(ctx context.Context, xpath string) (bool, error) {
-	var exists bool
-	js := fmt.Sprintf(`document.evaluate(%s, document, null, XPathResult.FIRST_ORDERED_NODE_TYPE, null).singleNodeValue !== null`, jsonEscape(xpath))
-	err := chromedp.Run(ctx, chromedp.Evaluate(js, &exists))
-	return exists, err
-}
-
-func jsonEscape(s string) string {
-	escaped, _ := json.Marshal(s)
-	return string(escaped)
+type Action interface {
+	ActionType() string
+	Modifiers() StepModifiers
 }
 
-func elementExistsComplex(ctx context.Context, selectors []string) (bool, error) {
-	// Start with the first selector
-	var exists bool
+/*
+StepModifiers holds the flags every rule step may carry.
 
-	// Build JavaScript to handle shadow DOM and iframe piercing
-	selectorsJSON, err := json.Marshal(selectors)
-	if err != nil {
-		return false, err
-	}
-
-	js := fmt.Sprintf(`
-        (function() {
-            function findElement(selectors) {
-                let element = document;
-                
-                for (let i = 0; i < selectors.length; i++) {
-                    const selector = selectors[i];
-                    
-                    if (selector.startsWith('xpath/')) {
-                        // Handle XPath - evaluate against current context (document, shadowRoot, or iframe document)
-                        const xpath = selector.substring(6);
-                        const contextNode = element.nodeType === Node.DOCUMENT_NODE || element.nodeType === Node.DOCUMENT_FRAGMENT_NODE ? element : element.ownerDocument || document;
-                        const result = contextNode.evaluate(xpath, element, null, XPathResult.FIRST_ORDERED_NODE_TYPE, null);
-                        element = result.singleNodeValue;
-                    } else {
-                        // Handle CSS selector - works on Document, Element, and ShadowRoot
-                        element = element.querySelector(selector);
-                    }
-                    
-                    if (!element) return false;
-                    
-                    // Pierce shadow DOM if available
-                    if (element.shadowRoot) {
-                        element = element.shadowRoot;
-                    }
-                    // Pierce iframe if available and same-origin
-                    else if (element.tagName === 'IFRAME' && element.contentDocument) {
-                        element = element.contentDocument;
-                    }
-                }
-                
-                return element !== null && element !== document;
-            }
-            
-            return findElement(%s);
-        })()`, string(selectorsJSON))
-
-	err = chromedp.Run(ctx, chromedp.Evaluate(js, &exists))
-	return exists, err
+	optional: the step never fails the list it belongs to
+	negated: the step succeeds when its check fails and the other way around
+*/
+type StepModifiers struct {
+	Optional bool `json:"optional"`
+	Negated  bool `json:"negated"`
 }
 
-type Action interface {
-	ActionType() string
+func (s StepModifiers) Modifiers() StepModifiers {
+	return s
 }
 
 type ExistsAction struct {
 	Exists ElementSelector `json:"exists"`
+	StepModifiers
 }
 
 func (e ExistsAction) ActionType() string {
@@ -289,6 +174,7 @@ func (e ExistsAction) ActionType() string {
 type VisibleAction struct {
 	Visible ElementSelector `json:"visible"`
 	Check   string          `json:"check"`
+	StepModifiers
 }
 
 func (v VisibleAction) ActionType() string {
@@ -298,20 +184,14 @@ func (v VisibleAction) ActionType() string {
 type WaitForAction struct {
 	WaitFor ElementSelector `json:"waitFor"`
 	Timeout uint64          `json:"timeout"`
+	StepModifiers
 }
 
 func (w WaitForAction) Wait(ctx context.Context) bool {
-	interval := 100 * time.Millisecond
-	deadline := time.Now().Add(time.Duration(w.Timeout) * time.Millisecond)
-	for time.Now().Before(deadline) {
+	return waitUntil(ctx, w.Timeout, func() bool {
 		exists, err := w.WaitFor.ElementExists(ctx)
-		if err == nil && exists {
-			return true
-		}
-		chromedp.Run(ctx, chromedp.Sleep(interval))
-	}
-
-	return false
+		return err == nil && exists
+	})
 }
 
 func (w WaitForAction) ActionType() string {
@@ -322,20 +202,14 @@ type WaitForVisibleAction struct {
 	WaitFor ElementSelector `json:"waitForVisible"`
 	Timeout uint64          `json:"timeout"`
 	Check   string          `json:"check"`
+	StepModifiers
 }
 
 func (w WaitForVisibleAction) Wait(ctx context.Context) bool {
-	interval := 100 * time.Millisecond
-	deadline := time.Now().Add(time.Duration(w.Timeout) * time.Millisecond)
-	for time.Now().Before(deadline) {
-		exists, err := w.WaitFor.ElementExists(ctx)
-		if err == nil && exists {
-			return true
-		}
-		chromedp.Run(ctx, chromedp.Sleep(interval))
-	}
-
-	return false
+	return waitUntil(ctx, w.Timeout, func() bool {
+		visible, err := w.WaitFor.ElementVisible(ctx, w.Check)
+		return err == nil && visible
+	})
 }
 
 func (w WaitForVisibleAction) ActionType() string {
@@ -345,6 +219,7 @@ func (w WaitForVisibleAction) ActionType() string {
 type ClickAction struct {
 	Click ElementSelector `json:"click"`
 	All   bool            `json:"all"`
+	StepModifiers
 }
 
 func (c ClickAction) ActionType() string {
@@ -354,7 +229,8 @@ func (c ClickAction) ActionType() string {
 type WaitForThenClickAction struct {
 	WaitFor ElementSelector `json:"waitForThenClick"`
 	Timeout uint64          `json:"timeout"`
-	Check   string          `json:"check"`
+	All     bool            `json:"all"`
+	StepModifiers
 }
 
 func (w WaitForThenClickAction) ActionType() string {
@@ -362,61 +238,102 @@ func (w WaitForThenClickAction) ActionType() string {
 }
 
 func (w WaitForThenClickAction) WaitForClick(ctx context.Context) error {
-	interval := 100 * time.Millisecond
-	deadline := time.Now().Add(time.Duration(w.Timeout) * time.Millisecond)
-	for time.Now().Before(deadline) {
+	found := waitUntil(ctx, w.Timeout, func() bool {
 		exists, err := w.WaitFor.ElementExists(ctx)
-		if err == nil && exists {
-			// Add a small delay to ensure element is ready for interaction
-			chromedp.Run(ctx, chromedp.Sleep(200*time.Millisecond))
-			return w.WaitFor.Click(ctx)
-		}
-		chromedp.Run(ctx, chromedp.Sleep(interval))
+		return err == nil && exists
+	})
+	if !found {
+		return fmt.Errorf("timeout waiting for element to appear")
+	}
+	// Add a small delay to ensure element is ready for interaction
+	if err := sleep(ctx, 200*time.Millisecond); err != nil {
+		return err
+	}
+	clicked, err := w.WaitFor.Click(ctx, w.All)
+	if err != nil {
+		return err
+	}
+	if !clicked {
+		return fmt.Errorf("element disappeared before it could be clicked")
 	}
-	return fmt.Errorf("timeout waiting for element to appear")
+	return nil
 }
 
 type HideAction struct {
 	Hide   string `json:"hide"`
 	Method string `json:"method"`
+	StepModifiers
 }
 
 func (h HideAction) ActionType() string {
 	return "hide"
 }
 
+// Apply hides every element matching the CSS selector with an injected stylesheet,
+// using display: none unless Method is opacity.
+func (h HideAction) Apply(ctx context.Context) error {
+	return evaluate(ctx, "autoconsent.hide("+jsonEscape(h.Hide)+", "+jsonEscape(h.Method)+")", nil)
+}
+
 type CookieMatchAction struct {
 	CookieContains string `json:"cookieContains"`
+	StepModifiers
 }
 
 func (c CookieMatchAction) ActionType() string {
 	return "cookieMatch"
 }
 
+func (c CookieMatchAction) Matches(ctx context.Context) (bool, error) {
+	var matches bool
+	err := evaluateScript(ctx, "document.cookie.includes("+jsonEscape(c.CookieContains)+")", &matches)
+	return matches, err
+}
+
 type EvalAction struct {
 	Eval string `json:"eval"`
+	StepModifiers
 }
 
 func (e EvalAction) ActionType() string {
 	return "eval"
 }
 
-func (e EvalAction) Evaluate(ctx context.Context) error {
-	return chromedp.Run(ctx, chromedp.Evaluate("(()=>{"+JSEvals[e.Eval]+"})()", nil))
+// Evaluate runs the snippet registered for the eval id and reports whether its result is truthy.
+func (e EvalAction) Evaluate(ctx context.Context) (bool, error) {
+	expression, ok := lookupEval(e.Eval)
+	if !ok {
+		return false, fmt.Errorf("unknown eval id: %s", e.Eval)
+	}
+	var truthy bool
+	err := evaluateScript(ctx, "!!"+expression, &truthy)
+	return truthy, err
 }
 
 type IfThenElseAction struct {
 	If   Action     `json:"if"`
 	Then ActionList `json:"then"`
 	Else ActionList `json:"else"`
+	StepModifiers
 }
 
 func (i IfThenElseAction) ActionType() string {
 	return "ifThenElse"
 }
 
+// AnyAction succeeds as soon as one of its steps succeeds.
+type AnyAction struct {
+	Any ActionList `json:"any"`
+	StepModifiers
+}
+
+func (a AnyAction) ActionType() string {
+	return "any"
+}
+
 type UnconditionalWaitAction struct {
 	WaitTime uint64 `json:"wait"`
+	StepModifiers
 }
 
 func (u UnconditionalWaitAction) ActionType() string {
@@ -424,7 +341,34 @@ func (u UnconditionalWaitAction) ActionType() string {
 }
 
 func (u UnconditionalWaitAction) Wait(ctx context.Context) {
-	chromedp.Run(ctx, chromedp.Sleep(time.Duration(u.WaitTime)*time.Millisecond))
+	sleep(ctx, time.Duration(u.WaitTime)*time.Millisecond)
+}
+
+// waitUntil polls check until it reports true, timeout milliseconds pass or ctx is cancelled.
+func waitUntil(ctx context.Context, timeout uint64, check func() bool) bool {
+	interval := 100 * time.Millisecond
+	deadline := time.Now().Add(time.Duration(timeout) * time.Millisecond)
+	for time.Now().Before(deadline) {
+		if check() {
+			return true
+		}
+		if sleep(ctx, interval) != nil {
+			return false
+		}
+	}
+	return false
+}
+
+// sleep pauses for d, returning the context's error early if it is cancelled first.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
 }
 
 type ActionList []Action
@@ -436,140 +380,11 @@ func (al *ActionList) UnmarshalJSON(data []byte) error {
 	}
 	var result []Action
 	for _, raw := range rawList {
-		var act Action
-		switch {
-		case raw["exists"] != nil:
-			var a ExistsAction
-			b, _ := json.Marshal(raw)
-			if err := json.Unmarshal(b, &a); err != nil {
-				return err
-			}
-			act = a
-		case raw["visible"] != nil:
-			var a VisibleAction
-			b, _ := json.Marshal(raw)
-			if err := json.Unmarshal(b, &a); err != nil {
-				return err
-			}
-			act = a
-		case raw["waitFor"] != nil:
-			var a WaitForAction
-			b, _ := json.Marshal(raw)
-			if err := json.Unmarshal(b, &a); err != nil {
-				return err
-			}
-			// Set default timeout if not specified
-			if a.Timeout == 0 {
-				a.Timeout = 1000
-			}
-			act = a
-		case raw["waitForVisible"] != nil:
-			var a WaitForVisibleAction
-			b, _ := json.Marshal(raw)
-			if err := json.Unmarshal(b, &a); err != nil {
-				return err
-			}
-			// Set default timeout if not specified
-			if a.Timeout == 0 {
-				a.Timeout = 1000
-			}
-			act = a
-		case raw["click"] != nil:
-			var a ClickAction
-			b, _ := json.Marshal(raw)
-			if err := json.Unmarshal(b, &a); err != nil {
-				return err
-			}
-			act = a
-		case raw["waitForThenClick"] != nil:
-			var a WaitForThenClickAction
-			b, _ := json.Marshal(raw)
-			if err := json.Unmarshal(b, &a); err != nil {
-				return err
-			}
-			// Set default timeout if not specified
-			if a.Timeout == 0 {
-				a.Timeout = 1000
-			}
-			act = a
-		case raw["hide"] != nil:
-			var a HideAction
-			b, _ := json.Marshal(raw)
-			if err := json.Unmarshal(b, &a); err != nil {
-				return err
-			}
-			act = a
-		case raw["cookieContains"] != nil:
-			var a CookieMatchAction
-			b, _ := json.Marshal(raw)
-			if err := json.Unmarshal(b, &a); err != nil {
-				return err
-			}
-			act = a
-		case raw["eval"] != nil:
-			var a EvalAction
-			b, _ := json.Marshal(raw)
-			if err := json.Unmarshal(b, &a); err != nil {
-				return err
-			}
-			act = a
-		case raw["wait"] != nil:
-			var a UnconditionalWaitAction
-			b, _ := json.Marshal(raw)
-			if err := json.Unmarshal(b, &a); err != nil {
-				return err
-			}
-			act = a
-		case raw["if"] != nil:
-			var a IfThenElseAction
-
-			// Handle the nested structure manually
-			var rawIf map[string]interface{}
-			if ifVal, ok := raw["if"].(map[string]interface{}); ok {
-				rawIf = ifVal
-			} else {
-				return fmt.Errorf("if field must be an object")
-			}
-
-			// Parse the "if" action
-			var ifAction Action
-			switch {
-			case rawIf["exists"] != nil:
-				var ea ExistsAction
-				ifBytes, _ := json.Marshal(rawIf)
-				if err := json.Unmarshal(ifBytes, &ea); err != nil {
-					return err
-				}
-				ifAction = ea
-			case rawIf["visible"] != nil:
-				var va VisibleAction
-				ifBytes, _ := json.Marshal(rawIf)
-				if err := json.Unmarshal(ifBytes, &va); err != nil {
-					return err
-				}
-				ifAction = va
-			default:
-				return fmt.Errorf("unsupported if action type: %+v", rawIf)
-			}
-
-			a.If = ifAction
-
-			// Parse "then" and "else" normally
-			if then, ok := raw["then"]; ok {
-				thenBytes, _ := json.Marshal(then)
-				if err := json.Unmarshal(thenBytes, &a.Then); err != nil {
-					return err
-				}
-			}
-			if else_, ok := raw["else"]; ok {
-				elseBytes, _ := json.Marshal(else_)
-				if err := json.Unmarshal(elseBytes, &a.Else); err != nil {
-					return err
-				}
-			}
-
-			act = a
-		default:
+		act, err := parseAction(raw)
+		if err != nil {
+			return err
+		}
+		if act == nil {
 			continue
 			//return fmt.Errorf("unknown action type: %+v", raw)
 		}
@@ -578,3 +393,120 @@ func (al *ActionList) UnmarshalJSON(data []byte) error {
 	*al = result
 	return nil
 }
+
+// parseAction decodes a single rule step, returning a nil Action for step types it doesn't know.
+func parseAction(raw map[string]interface{}) (Action, error) {
+	text, _ := raw["text"].(string)
+
+	switch {
+	case raw["exists"] != nil:
+		var a ExistsAction
+		err := decodeStep(raw, &a)
+		a.Exists.Text = text
+		return a, err
+	case raw["visible"] != nil:
+		var a VisibleAction
+		err := decodeStep(raw, &a)
+		a.Visible.Text = text
+		if a.Check == "" {
+			a.Check = CheckAll
+		}
+		return a, err
+	case raw["waitFor"] != nil:
+		var a WaitForAction
+		err := decodeStep(raw, &a)
+		a.WaitFor.Text = text
+		// Set default timeout if not specified
+		if a.Timeout == 0 {
+			a.Timeout = 1000
+		}
+		return a, err
+	case raw["waitForVisible"] != nil:
+		var a WaitForVisibleAction
+		err := decodeStep(raw, &a)
+		a.WaitFor.Text = text
+		// Set default timeout if not specified
+		if a.Timeout == 0 {
+			a.Timeout = 1000
+		}
+		if a.Check == "" {
+			a.Check = CheckAny
+		}
+		return a, err
+	case raw["click"] != nil:
+		var a ClickAction
+		err := decodeStep(raw, &a)
+		a.Click.Text = text
+		return a, err
+	case raw["waitForThenClick"] != nil:
+		var a WaitForThenClickAction
+		err := decodeStep(raw, &a)
+		// Set default timeout if not specified
+		if a.Timeout == 0 {
+			a.Timeout = 1000
+		}
+		a.WaitFor.Text = text
+		return a, err
+	case raw["hide"] != nil:
+		var a HideAction
+		err := decodeStep(raw, &a)
+		return a, err
+	case raw["cookieContains"] != nil:
+		var a CookieMatchAction
+		err := decodeStep(raw, &a)
+		return a, err
+	case raw["eval"] != nil:
+		var a EvalAction
+		err := decodeStep(raw, &a)
+		return a, err
+	case raw["wait"] != nil:
+		var a UnconditionalWaitAction
+		err := decodeStep(raw, &a)
+		return a, err
+	case raw["any"] != nil:
+		var a AnyAction
+		err := decodeStep(raw, &a)
+		return a, err
+	case raw["if"] != nil:
+		var a IfThenElseAction
+
+		// Handle the nested structure manually
+		rawIf, ok := raw["if"].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("if field must be an object")
+		}
+		ifAction, err := parseAction(rawIf)
+		if err != nil {
+			return nil, err
+		}
+		if ifAction == nil {
+			return nil, fmt.Errorf("unsupported if action type: %+v", rawIf)
+		}
+		a.If = ifAction
+
+		// Parse "then" and "else" normally
+		if then, ok := raw["then"]; ok {
+			if err := decodeStep(then, &a.Then); err != nil {
+				return nil, err
+			}
+		}
+		if else_, ok := raw["else"]; ok {
+			if err := decodeStep(else_, &a.Else); err != nil {
+				return nil, err
+			}
+		}
+		err = decodeStep(raw, &a.StepModifiers)
+		return a, err
+	default:
+		return nil, nil
+	}
+}
+
+// decodeStep converts an already decoded JSON value into v.
+func decodeStep(raw interface{}, v interface{}) error {
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,90 @@
+package autoconsent
+
+import (
+	"context"
+	"encoding/json"
+)
+
+/*
+probeStep is the JSON form of an exists or visible step handed to the in-page probe.
+
+	exists / visible: the selector of the step, only one of them is set
+	text: the selector's text filter
+	check: the visibility check of a visible step
+*/
+type probeStep struct {
+	Exists   interface{} `json:"exists,omitempty"`
+	Visible  interface{} `json:"visible,omitempty"`
+	Text     string      `json:"text,omitempty"`
+	Check    string      `json:"check,omitempty"`
+	Negated  bool        `json:"negated,omitempty"`
+	Optional bool        `json:"optional,omitempty"`
+}
+
+// probeScript runs each list of probe steps with upstream step semantics and returns one boolean per list.
+// A list with an invalid selector fails on its own instead of failing the whole probe.
+const probeScript = `lists.map((steps) => {
+	try {
+		return steps.every((step) => {
+			let ok = step.exists !== undefined
+				? autoconsent.find(step.exists, step.text).length > 0
+				: autoconsent.visible(step.visible, step.text, step.check);
+			if (step.negated) {
+				ok = !ok;
+			}
+			return ok || !!step.optional;
+		});
+	} catch (e) {
+		return false;
+	}
+})`
+
+// Probeable reports whether actions only contains exists and visible steps, which can be
+// answered without waiting and so can be batched with Probe.
+func Probeable(actions ActionList) bool {
+	for _, action := range actions {
+		switch action.(type) {
+		case ExistsAction, VisibleAction:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Probe evaluates several Probeable step lists in a single evaluation and reports which of them passed.
+func Probe(ctx context.Context, lists []ActionList) ([]bool, error) {
+	encoded := make([][]probeStep, len(lists))
+	for i, actions := range lists {
+		for _, action := range actions {
+			switch a := action.(type) {
+			case ExistsAction:
+				encoded[i] = append(encoded[i], probeStep{
+					Exists:   a.Exists.Element,
+					Text:     a.Exists.Text,
+					Negated:  a.Negated,
+					Optional: a.Optional,
+				})
+			case VisibleAction:
+				encoded[i] = append(encoded[i], probeStep{
+					Visible:  a.Visible.Element,
+					Text:     a.Visible.Text,
+					Check:    a.Check,
+					Negated:  a.Negated,
+					Optional: a.Optional,
+				})
+			}
+		}
+		if encoded[i] == nil {
+			encoded[i] = []probeStep{}
+		}
+	}
+	data, err := json.Marshal(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []bool
+	err = evaluate(ctx, "((lists) => "+probeScript+")("+string(data)+")", &results)
+	return results, err
+}
@@ -0,0 +1,308 @@
+package autoconsent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+)
+
+// domHelpers is prepended to every selector evaluation. It resolves ElementSelectors the way
+// upstream autoconsent does: a plain string is a CSS selector or an XPath prefixed with xpath/,
+// and an array is a chain where each link is searched inside the first match of the previous
+// one, piercing shadow roots and same-origin iframes along the way. A text filter keeps the
+// matches whose rendered text contains it, or matches it when it is a /regex/flags literal.
+// An element is visible when it is rendered with a non-empty box, and so are its ancestors
+// and the iframes it is nested in.
+const domHelpers = `
+const autoconsent = {
+	query(selector, parent) {
+		if (selector.startsWith('xpath/')) {
+			const doc = parent.nodeType === Node.DOCUMENT_NODE ? parent : parent.ownerDocument || document;
+			const result = doc.evaluate(selector.substring(6), parent, null, XPathResult.ORDERED_NODE_SNAPSHOT_TYPE, null);
+			const nodes = [];
+			for (let i = 0; i < result.snapshotLength; i++) {
+				nodes.push(result.snapshotItem(i));
+			}
+			return nodes;
+		}
+		return Array.from(parent.querySelectorAll(selector));
+	},
+	find(selectors, text) {
+		if (!Array.isArray(selectors)) {
+			selectors = [selectors];
+		}
+		let parent = document;
+		let matches = [];
+		for (const selector of selectors) {
+			matches = this.query(selector, parent);
+			if (matches.length === 0) {
+				return [];
+			}
+			parent = matches[0];
+			if (parent.shadowRoot) {
+				parent = parent.shadowRoot;
+			} else if (parent.tagName === 'IFRAME' && parent.contentDocument) {
+				parent = parent.contentDocument;
+			}
+		}
+		if (text) {
+			matches = matches.filter((el) => this.matchesText(el, text));
+		}
+		return matches;
+	},
+	matchesText(el, text) {
+		const content = (el.innerText || el.textContent || '').trim();
+		const literal = /^\/(.+)\/([dgimsuvy]*)$/.exec(text);
+		if (literal) {
+			return new RegExp(literal[1], literal[2]).test(content);
+		}
+		return content.includes(text);
+	},
+	isVisible(el) {
+		if (!el.isConnected || el.nodeType !== Node.ELEMENT_NODE) {
+			return false;
+		}
+		// opacity is deliberately ignored, it is how prehidden banners are hidden while they are detected
+		const style = el.ownerDocument.defaultView.getComputedStyle(el);
+		if (style.display === 'none' || style.visibility === 'hidden' || style.visibility === 'collapse') {
+			return false;
+		}
+		// covers ancestors, including shadow hosts, that are display: none or content-visibility: hidden
+		if (el.checkVisibility && !el.checkVisibility({visibilityProperty: true})) {
+			return false;
+		}
+		const rect = el.getBoundingClientRect();
+		if (rect.width === 0 && rect.height === 0) {
+			return false;
+		}
+		// an element inside an iframe is only seen if the iframe is
+		const frame = el.ownerDocument.defaultView.frameElement;
+		return !frame || this.isVisible(frame);
+	},
+	visible(selectors, text, check) {
+		const results = this.find(selectors, text).map((el) => this.isVisible(el));
+		if (check === 'none') {
+			return results.every((r) => !r);
+		}
+		if (results.length === 0) {
+			return false;
+		}
+		if (check === 'any') {
+			return results.some((r) => r);
+		}
+		return results.every((r) => r);
+	},
+	click(selectors, text, all) {
+		const elements = this.find(selectors, text);
+		if (elements.length === 0) {
+			return false;
+		}
+		for (const el of all ? elements : elements.slice(0, 1)) {
+			el.scrollIntoView({behavior: 'instant', block: 'center'});
+			el.click();
+		}
+		return true;
+	},
+	hideElements(selectors, text) {
+		let hidden = 0;
+		for (const el of this.find(selectors, text)) {
+			const doc = el.ownerDocument;
+			if (!el.style || el === doc.documentElement || el === doc.body) {
+				continue;
+			}
+			el.style.setProperty('display', 'none', 'important');
+			hidden++;
+		}
+		return hidden;
+	},
+	hide(selector, method) {
+		const rule = method === 'opacity'
+			? 'opacity: 0 !important; z-index: -1 !important; pointer-events: none !important;'
+			: 'display: none !important;';
+		let style = document.getElementById('autoconsent-hide');
+		if (!style) {
+			style = document.createElement('style');
+			style.id = 'autoconsent-hide';
+			(document.head || document.documentElement).appendChild(style);
+		}
+		style.textContent += selector + ' { ' + rule + ' }\n';
+		return true;
+	},
+};
+`
+
+// prehideStyleID is the id of the stylesheet installed by PrehideScript.
+const prehideStyleID = "autoconsent-prehide"
+
+// PrehideScript returns a script that, evaluated at document start, hides every element matching
+// one of the CSS selectors. Each selector gets its own rule so one invalid selector can't disable the rest.
+func PrehideScript(selectors []string) string {
+	var css strings.Builder
+	for _, selector := range selectors {
+		css.WriteString(selector + " { opacity: 0 !important; z-index: -1 !important; pointer-events: none !important; }\n")
+	}
+	return fmt.Sprintf(`(() => {
+	const apply = () => {
+		const style = document.createElement('style');
+		style.id = %s;
+		style.textContent = %s;
+		(document.head || document.documentElement).appendChild(style);
+	};
+	if (document.documentElement) {
+		apply();
+		return;
+	}
+	new MutationObserver((_, observer) => {
+		if (document.documentElement) {
+			observer.disconnect();
+			apply();
+		}
+	}).observe(document, {childList: true});
+})()`, jsonEscape(prehideStyleID), jsonEscape(css.String()))
+}
+
+// UndoPrehide removes the stylesheet installed by PrehideScript from the current document.
+func UndoPrehide(ctx context.Context) error {
+	return evaluateScript(ctx, "document.getElementById("+jsonEscape(prehideStyleID)+")?.remove()", nil)
+}
+
+// UnblockScroll lifts the overflow lock banners put on the page while they are shown,
+// so content below the fold can be scrolled to and captured again.
+func UnblockScroll(ctx context.Context) error {
+	return evaluateScript(ctx, `[document.documentElement, document.body].forEach((el) => {
+	if (el && getComputedStyle(el).overflowY === 'hidden') {
+		el.style.setProperty('overflow', 'auto', 'important');
+	}
+})`, nil)
+}
+
+// Visibility checks accepted by visible and waitForVisible steps.
+const (
+	CheckAll  = "all"  // every matched element is visible
+	CheckAny  = "any"  // at least one matched element is visible
+	CheckNone = "none" // no matched element is visible, which includes nothing matching
+)
+
+type executionContextKey struct{}
+
+// InExecutionContext returns a context whose evaluations run in the JavaScript execution
+// context id instead of the target's main world. It is used for child frames that live
+// in the same target as the page and so can't be reached through a target of their own.
+func InExecutionContext(ctx context.Context, id runtime.ExecutionContextID) context.Context {
+	return context.WithValue(ctx, executionContextKey{}, id)
+}
+
+// evaluateScript evaluates js in the target behind ctx, or in the execution context set by
+// InExecutionContext, and stores its result in res.
+func evaluateScript(ctx context.Context, js string, res interface{}) error {
+	var opts []chromedp.EvaluateOption
+	if id, ok := ctx.Value(executionContextKey{}).(runtime.ExecutionContextID); ok {
+		opts = append(opts, func(p *runtime.EvaluateParams) *runtime.EvaluateParams {
+			return p.WithContextID(id)
+		})
+	}
+	return chromedp.Run(ctx, chromedp.Evaluate(js, res, opts...))
+}
+
+// evaluate runs expression with the domHelpers in scope and stores its result in res.
+func evaluate(ctx context.Context, expression string, res interface{}) error {
+	js := "(() => {" + domHelpers + "return " + expression + ";\n})()"
+	return evaluateScript(ctx, js, res)
+}
+
+// args encodes the selector and its text filter as JavaScript arguments for the domHelpers.
+func (e *ElementSelector) args() (string, error) {
+	switch e.Element.(type) {
+	case string, []string:
+	default:
+		return "", fmt.Errorf("unsupported selector type: %T", e.Element)
+	}
+	selector, err := json.Marshal(e.Element)
+	if err != nil {
+		return "", err
+	}
+	return string(selector) + ", " + jsonEscape(e.Text), nil
+}
+
+func (e *ElementSelector) ElementExists(ctx context.Context) (bool, error) {
+	args, err := e.args()
+	if err != nil {
+		return false, err
+	}
+	var exists bool
+	err = evaluate(ctx, "autoconsent.find("+args+").length > 0", &exists)
+	return exists, err
+}
+
+// ElementVisible reports whether the matched elements pass check, one of CheckAll, CheckAny or CheckNone.
+func (e *ElementSelector) ElementVisible(ctx context.Context, check string) (bool, error) {
+	args, err := e.args()
+	if err != nil {
+		return false, err
+	}
+	var visible bool
+	err = evaluate(ctx, "autoconsent.visible("+args+", "+jsonEscape(check)+")", &visible)
+	return visible, err
+}
+
+// Click clicks the first matched element, or every one of them when all is set.
+// It reports whether anything was clicked.
+func (e *ElementSelector) Click(ctx context.Context, all bool) (bool, error) {
+	args, err := e.args()
+	if err != nil {
+		return false, err
+	}
+	var clicked bool
+	err = evaluate(ctx, fmt.Sprintf("autoconsent.click(%s, %t)", args, all), &clicked)
+	return clicked, err
+}
+
+// Hide hides every matched element with an inline display: none, which also reaches elements in
+// shadow roots and those only an XPath or a chain selects. A document's root and body are left alone.
+// It returns the number of elements hidden.
+func (e *ElementSelector) Hide(ctx context.Context) (int, error) {
+	args, err := e.args()
+	if err != nil {
+		return 0, err
+	}
+	var hidden int
+	err = evaluate(ctx, "autoconsent.hideElements("+args+")", &hidden)
+	return hidden, err
+}
+
+// Box is the position and size of an element in CSS pixels, relative to the top left corner of the document.
+type Box struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// Box returns the box of the first matched element, and false when nothing matches.
+func (e *ElementSelector) Box(ctx context.Context) (Box, bool, error) {
+	args, err := e.args()
+	if err != nil {
+		return Box{}, false, err
+	}
+	var box *Box
+	err = evaluate(ctx, `((el) => {
+	if (!el) {
+		return null;
+	}
+	const rect = el.getBoundingClientRect();
+	return {x: rect.left + window.scrollX, y: rect.top + window.scrollY, width: rect.width, height: rect.height};
+})(autoconsent.find(`+args+`)[0])`, &box)
+	if err != nil || box == nil {
+		return Box{}, false, err
+	}
+	return *box, true, nil
+}
+
+func jsonEscape(s string) string {
+	escaped, _ := json.Marshal(s)
+	return string(escaped)
+}
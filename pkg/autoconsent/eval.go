@@ -1,5 +1,6 @@
 package autoconsent
 
+// JSEvals holds the upstream eval snippets, registered at start up. Use RegisterEval or LoadEvals to add more.
 var JSEvals = map[string]string{
 	"EVAL_0":                "return console.log(1);",
 	"EVAL_CONSENTMANAGER_1": "window.__cmp && typeof __cmp('getCMPData') === 'object'",
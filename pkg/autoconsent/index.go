@@ -0,0 +1,129 @@
+package autoconsent
+
+import (
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/*
+ruleIndex narrows the rules that have to be considered for a URL.
+
+	byHost: rules keyed by the host their urlPattern is anchored to, see hostKey
+	unindexed: rules with a urlPattern that couldn't be reduced to a host
+	generic: rules without a urlPattern, which apply to every URL
+*/
+type ruleIndex struct {
+	byHost    map[string][]int
+	unindexed []int
+	generic   []int
+}
+
+var (
+	// hostLiteral matches an escaped dotted host name inside a pattern, like abc\.net\.au
+	hostLiteral = regexp.MustCompile(`(?:[A-Za-z0-9-]+\\\.)+[A-Za-z0-9-]+`)
+	// optionalSubdomain matches a group ending in an escaped dot right before a host literal, like ([a-z0-9-]+\.)?
+	optionalSubdomain = regexp.MustCompile(`\\\.\)[?*+]$`)
+	// hostEndGroup matches a group right after a host literal that starts the path or port, like (/|$)
+	hostEndGroup = regexp.MustCompile(`^\((\?:)?(\\/|[/$:])`)
+	// crossesPath matches what can also match a / before a host literal: an unescaped dot, a negated
+	// class or a negated escape, like the .* in ^https://.*\.example\.com/
+	crossesPath = regexp.MustCompile(`(^|[^\\])(\\\\)*(\.|\[\^|\\[SWD])`)
+)
+
+// hostKey returns the host a urlPattern is anchored to, such that every URL the pattern
+// matches has a host equal to the key or ending in "."+key. For example the key of
+// ^https://([a-z0-9-]+\.)?abc\.net\.au/ is abc.net.au. It returns "" for patterns
+// whose host can't be reduced to a fixed suffix.
+func hostKey(pattern string) string {
+	i := strings.Index(pattern, "://")
+	if i < 0 || topLevelAlternation(pattern) {
+		return ""
+	}
+	host := pattern[i+3:]
+	for _, loc := range hostLiteral.FindAllStringIndex(host, -1) {
+		before, after := host[:loc[0]], host[loc[1]:]
+		// whatever comes before the host must stay inside it, or the literal could be matched in the path
+		startsLabel := !crossesPath.MatchString(before) &&
+			(before == "" || strings.HasSuffix(before, `\.`) || optionalSubdomain.MatchString(before))
+		// a pattern that stops right after the host, like ^https://bbc\.com, is unanchored there
+		// and also matches longer hosts such as bbc.com.au, so it only counts with an explicit end
+		endsHost := after != "" && (strings.ContainsAny(after[:1], "/$:") || strings.HasPrefix(after, `\/`) || hostEndGroup.MatchString(after))
+		if startsLabel && endsHost {
+			return strings.ToLower(strings.ReplaceAll(host[loc[0]:loc[1]], `\.`, "."))
+		}
+	}
+	return ""
+}
+
+// topLevelAlternation reports whether pattern has a | outside any group or class, in which case
+// a host found in one branch says nothing about the URLs the others match.
+func topLevelAlternation(pattern string) bool {
+	depth, inClass := 0, false
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case c == '\\':
+			i++
+		case inClass:
+			inClass = c != ']'
+		case c == '[':
+			inClass = true
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == '|' && depth == 0:
+			return true
+		}
+	}
+	return false
+}
+
+// buildIndex compiles the lookup tables used by Candidates. It must be called whenever Rules changes.
+func (rs *AutoConsentRules) buildIndex() {
+	index := ruleIndex{byHost: map[string][]int{}}
+	for i, rule := range rs.Rules {
+		switch key := hostKey(rule.RunContext.UrlPattern); {
+		case rule.RunContext.UrlPattern == "":
+			index.generic = append(index.generic, i)
+		case key != "":
+			index.byHost[key] = append(index.byHost[key], i)
+		default:
+			index.unindexed = append(index.unindexed, i)
+		}
+	}
+	rs.index = index
+}
+
+// Candidates returns the indices in Rules of the rules whose urlPattern matches rawURL, in rule order.
+func (rs *AutoConsentRules) Candidates(rawURL string) []int {
+	candidates := rs.Lookup(rawURL)
+	matching := candidates[:0]
+	for _, i := range candidates {
+		if rs.Rules[i].RunContext.URLMatches(rawURL) {
+			matching = append(matching, i)
+		}
+	}
+	return matching
+}
+
+// Lookup returns the indices in Rules of the rules the index can't rule out for rawURL, in rule order,
+// before their urlPattern is checked. It is a superset of Candidates.
+func (rs *AutoConsentRules) Lookup(rawURL string) []int {
+	candidates := append([]int(nil), rs.index.generic...)
+	if parsed, err := url.Parse(rawURL); err == nil && parsed.Hostname() != "" {
+		host := strings.ToLower(parsed.Hostname())
+		for {
+			candidates = append(candidates, rs.index.byHost[host]...)
+			dot := strings.IndexByte(host, '.')
+			if dot < 0 {
+				break
+			}
+			host = host[dot+1:]
+		}
+	}
+	candidates = append(candidates, rs.index.unindexed...)
+	sort.Ints(candidates)
+	return candidates
+}
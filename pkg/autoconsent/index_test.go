@@ -0,0 +1,147 @@
+package autoconsent
+
+import (
+	"flag"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestHostKey(t *testing.T) {
+	cases := []struct {
+		pattern string
+		want    string
+	}{
+		{`^https://([a-z0-9-]+\.)?abc\.net\.au/`, "abc.net.au"},
+		{`^https?://(www\.)?example\.com/`, "example.com"},
+		{`^https://www\.example\.co\.uk(/|$)`, "www.example.co.uk"},
+		{`^https://shop\.example\.com:8443/`, "shop.example.com"},
+		{`^https://example\.com$`, "example.com"},
+		{`^https://example\.com\/privacy`, "example.com"},
+		{`^https://(www\.)?bbc\.com`, ""},
+		{`^https://ouraring\.com`, ""},
+		{`^https?://(www\.)?twitch\.tv`, ""},
+		{`^https://[^/]*example\.com/`, ""},
+		{`^https://.*\.aliexpress\.com/`, ""},
+		{`^https://a\.example\.com/|^https://b\.example\.org/`, ""},
+		{`^https://(a|b)\.example\.com/`, "example.com"},
+		{`example\.com`, ""},
+		{``, ""},
+	}
+	for _, c := range cases {
+		if got := hostKey(c.pattern); got != c.want {
+			t.Errorf("hostKey(%q) = %q, want %q", c.pattern, got, c.want)
+		}
+	}
+}
+
+// scan is the linear search the index replaces: every rule whose urlPattern matches rawURL, in rule order.
+func scan(rules *AutoConsentRules, rawURL string) []int {
+	var matching []int
+	for i, rule := range rules.Rules {
+		if rule.RunContext.URLMatches(rawURL) {
+			matching = append(matching, i)
+		}
+	}
+	return matching
+}
+
+// fullIndexScan checks the index against every host in the shipped patterns instead of a sample,
+// which takes minutes under the race detector.
+var fullIndexScan = flag.Bool("index.full", false, "compare Candidates with a linear scan for every host in rules.json")
+
+// sampledHosts is how many of the hosts in the shipped patterns TestCandidatesMatchScan checks by default.
+const sampledHosts = 30
+
+// probeURLs returns addresses built from the hosts named in the shipped patterns, including longer hosts,
+// other subdomains and paths that only look like a host, which an index keyed on the wrong host would miss.
+// Unless every host is asked for, an evenly spread sample of sampledHosts of them is used.
+func probeURLs(rules *AutoConsentRules, all bool) []string {
+	urls := []string{
+		"https://www.bbc.com.au/",
+		"https://ouraring.com.evil.net/",
+		"https://twitch.tvx.example/",
+		"https://unrelated.example/best.aliexpress.com/",
+		"https://example.org/",
+	}
+	var hosts []string
+	seen := map[string]bool{}
+	for _, rule := range rules.Rules {
+		for _, literal := range hostLiteral.FindAllString(rule.RunContext.UrlPattern, -1) {
+			host := strings.ToLower(strings.ReplaceAll(literal, `\.`, "."))
+			if !seen[host] {
+				seen[host] = true
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	step := 1
+	if !all && len(hosts) > sampledHosts {
+		step = len(hosts) / sampledHosts
+	}
+	for i := 0; i < len(hosts); i += step {
+		host := hosts[i]
+		urls = append(urls,
+			"https://"+host+"/",
+			"http://www."+host+"/path",
+			"https://"+host+".au/",
+			"https://"+host+"x.example/",
+			"https://"+host+":8443/",
+			"https://unrelated.example/"+host+"/",
+		)
+	}
+	return urls
+}
+
+func TestCandidatesMatchScan(t *testing.T) {
+	rules, err := LoadDefault()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rawURL := range probeURLs(rules, *fullIndexScan) {
+		want := scan(rules, rawURL)
+		got := rules.Candidates(rawURL)
+		if !slices.Equal(got, want) {
+			t.Errorf("Candidates(%s) = %v, want %v", rawURL, names(rules, got), names(rules, want))
+		}
+	}
+}
+
+func names(rules *AutoConsentRules, indices []int) []string {
+	var names []string
+	for _, i := range indices {
+		names = append(names, rules.Rules[i].Name)
+	}
+	return names
+}
+
+// benchmarkURLs is a mix of addresses with and without a site specific rule.
+var benchmarkURLs = []string{
+	"https://www.bbc.com/news",
+	"https://www.nba.com/",
+	"https://www.theguardian.com/uk",
+	"https://example.org/some/page",
+	"https://shop.example.co.uk/basket",
+}
+
+func BenchmarkCandidates(b *testing.B) {
+	rules, err := LoadDefault()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rules.Candidates(benchmarkURLs[i%len(benchmarkURLs)])
+	}
+}
+
+func BenchmarkURLMatchesScan(b *testing.B) {
+	rules, err := LoadDefault()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		scan(rules, benchmarkURLs[i%len(benchmarkURLs)])
+	}
+}
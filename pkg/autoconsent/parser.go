@@ -1,27 +1,260 @@
 package autoconsent
 
 import (
+	"bytes"
+	"context"
+	_ "embed"
 	"encoding/json"
+	"fmt"
+	"io"
+	"log"
 	"os"
-	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-var Rules AutoConsentRules
+// defaultRules is the upstream DuckDuckGo rule set shipped with the scraper.
+//
+//go:embed rules.json
+var defaultRules []byte
 
-func init() {
-	exePath, err := os.Executable()
+// LoadReader decodes a rule file in the rules.json format and prepares it for matching.
+func LoadReader(r io.Reader) (*AutoConsentRules, error) {
+	var rules AutoConsentRules
+	if err := json.NewDecoder(r).Decode(&rules); err != nil {
+		return nil, fmt.Errorf("decoding consent rules: %w", err)
+	}
+	if err := rules.checkEvals(); err != nil {
+		return nil, err
+	}
+	rules.buildIndex()
+	return &rules, nil
+}
+
+// Load reads a rule file in the rules.json format from path.
+func Load(path string) (*AutoConsentRules, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rules, err := LoadReader(f)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// LoadDefault returns the rule set embedded in the binary.
+func LoadDefault() (*AutoConsentRules, error) {
+	return LoadReader(bytes.NewReader(defaultRules))
+}
+
+// UpstreamSource is the source of the rules in the upstream rule file, embedded or not.
+const UpstreamSource = "upstream"
+
+/*
+RuleSource lists the rules loaded from one rule file.
+
+	source: the path of an extra rule file, or UpstreamSource
+	rules: the names of the rules in use from the file, in the order they are tried
+	disabled: the upstream rules left out by name
+	overridden: the upstream rules left out because an extra rule file has a rule of the same name
+	unknown_disabled: the names asked to be disabled that no upstream rule has, likely misspelt
+*/
+type RuleSource struct {
+	Source          string   `json:"source"`
+	Rules           []string `json:"rules"`
+	Disabled        []string `json:"disabled,omitempty"`
+	Overridden      []string `json:"overridden,omitempty"`
+	UnknownDisabled []string `json:"unknown_disabled,omitempty"`
+}
+
+// Sources returns the rule files the rule set was merged from, in order of precedence.
+func (r *AutoConsentRules) Sources() []RuleSource {
+	return r.sources
+}
+
+/*
+StoreConfig selects the rule files a Store loads.
+
+	Path: the upstream rule file, the embedded rules when empty
+	Extra: rule files whose rules are tried before the upstream rules, in order, and replace upstream rules of the same name
+	Disabled: names of upstream rules that are never used
+*/
+type StoreConfig struct {
+	Path     string
+	Extra    []string
+	Disabled []string
+}
+
+/*
+Store holds the rule set in use and swaps it atomically when a rule file is reloaded,
+so requests in flight keep the rules they started with.
+
+	modTimes: the modification time of each rule file when Reload last read it, which Watch compares against
+*/
+type Store struct {
+	current atomic.Pointer[AutoConsentRules]
+	config  StoreConfig
+
+	mu       sync.Mutex
+	modTimes map[string]time.Time
+}
+
+// NewStore loads the rule files selected by config.
+func NewStore(config StoreConfig) (*Store, error) {
+	s := &Store{config: config}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Rules returns the current rule set.
+func (s *Store) Rules() *AutoConsentRules {
+	return s.current.Load()
+}
+
+// paths returns the rule files on disk that make up the rule set.
+func (s *Store) paths() []string {
+	paths := slices.Clone(s.config.Extra)
+	if s.config.Path != "" {
+		paths = append(paths, s.config.Path)
 	}
-	dir := filepath.Dir(exePath)
-	rulesPath := filepath.Join(dir, "rules.json")
+	return paths
+}
+
+// Reload reads the rule files again and makes the merged rules current. The previous rules stay in use if any of them fails.
+// Disabled names that match no upstream rule are logged and listed in the upstream RuleSource.
+func (s *Store) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	data, err := os.ReadFile(rulesPath)
+	modTimes := map[string]time.Time{}
+	for _, path := range s.paths() {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		modTimes[path] = info.ModTime()
+	}
+	// recorded even if loading fails, so Watch reports a broken file once rather than on every tick
+	s.modTimes = modTimes
+
+	var extra []*AutoConsentRules
+	for _, path := range s.config.Extra {
+		rules, err := Load(path)
+		if err != nil {
+			return err
+		}
+		extra = append(extra, rules)
+	}
+	var upstream *AutoConsentRules
+	var err error
+	if s.config.Path == "" {
+		upstream, err = LoadDefault()
+	} else {
+		upstream, err = Load(s.config.Path)
+	}
 	if err != nil {
-		panic(err)
+		return err
+	}
+
+	merged := s.merge(extra, upstream)
+	if unknown := merged.sources[len(merged.sources)-1].UnknownDisabled; len(unknown) > 0 {
+		log.Printf("autoconsent: no upstream rule to disable named %s", strings.Join(unknown, ", "))
+	}
+	s.current.Store(merged)
+	return nil
+}
+
+// merge combines the extra rule sets and the upstream rules into a single rule set, in order of precedence.
+func (s *Store) merge(extra []*AutoConsentRules, upstream *AutoConsentRules) *AutoConsentRules {
+	merged := &AutoConsentRules{}
+	overrides := map[string]bool{}
+	for i, rules := range extra {
+		source := RuleSource{Source: s.config.Extra[i]}
+		for _, rule := range rules.Rules {
+			overrides[rule.Name] = true
+			merged.Rules = append(merged.Rules, rule)
+			source.Rules = append(source.Rules, rule.Name)
+		}
+		merged.sources = append(merged.sources, source)
+	}
+
+	source := RuleSource{Source: UpstreamSource}
+	for _, rule := range upstream.Rules {
+		switch {
+		case slices.Contains(s.config.Disabled, rule.Name):
+			source.Disabled = append(source.Disabled, rule.Name)
+		case overrides[rule.Name]:
+			source.Overridden = append(source.Overridden, rule.Name)
+		default:
+			merged.Rules = append(merged.Rules, rule)
+			source.Rules = append(source.Rules, rule.Name)
+		}
 	}
+	for _, name := range s.config.Disabled {
+		if !slices.Contains(source.Disabled, name) && !slices.Contains(source.UnknownDisabled, name) {
+			source.UnknownDisabled = append(source.UnknownDisabled, name)
+		}
+	}
+	merged.sources = append(merged.sources, source)
+	merged.buildIndex()
+	return merged
+}
+
+// Watch checks the rule files for changes every interval and reloads them when one was modified,
+// until ctx is cancelled. It does nothing when only the embedded rules are used.
+// Files are compared with what Reload last read, so a reload done elsewhere isn't repeated.
+func (s *Store) Watch(ctx context.Context, interval time.Duration) {
+	paths := s.paths()
+	if len(paths) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		changed := s.changed(paths)
+		if len(changed) == 0 {
+			continue
+		}
+		files := strings.Join(changed, ", ")
+		if err := s.Reload(); err != nil {
+			log.Printf("autoconsent: reloading after %s changed: %v", files, err)
+			continue
+		}
+		log.Printf("autoconsent: reloaded after %s changed", files)
+	}
+}
+
+// changed returns the paths modified since Reload last read them.
+func (s *Store) changed(paths []string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	if err := json.Unmarshal(data, &Rules); err != nil {
-		panic(err)
+	var changed []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Printf("autoconsent: checking %s: %v", path, err)
+			continue
+		}
+		if !info.ModTime().Equal(s.modTimes[path]) {
+			changed = append(changed, path)
+		}
 	}
+	return changed
 }
@@ -0,0 +1,72 @@
+package autoconsent
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestUnknownDisabledRules(t *testing.T) {
+	store, err := NewStore(StoreConfig{Disabled: []string{"quantcast", "no-such-rule", "no-such-rule"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources := store.Rules().Sources()
+	upstream := sources[len(sources)-1]
+	if !slices.Equal(upstream.Disabled, []string{"quantcast"}) {
+		t.Errorf("Disabled = %v, want [quantcast]", upstream.Disabled)
+	}
+	if !slices.Equal(upstream.UnknownDisabled, []string{"no-such-rule"}) {
+		t.Errorf("UnknownDisabled = %v, want [no-such-rule]", upstream.UnknownDisabled)
+	}
+}
+
+func TestChangedSinceReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "extra.json")
+	modified := time.Now()
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		// a second apart, so the change shows on file systems with coarse modification times
+		modified = modified.Add(time.Second)
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"autoconsent": [{"name": "before"}]}`)
+	store, err := NewStore(StoreConfig{Extra: []string{path}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := store.paths()
+	if changed := store.changed(paths); len(changed) != 0 {
+		t.Errorf("changed after loading = %v, want none", changed)
+	}
+
+	// a reload outside Watch, such as on SIGHUP, leaves nothing for the next poll to reload
+	write(`{"autoconsent": [{"name": "after"}]}`)
+	if changed := store.changed(paths); !slices.Equal(changed, []string{path}) {
+		t.Errorf("changed after writing = %v, want [%s]", changed, path)
+	}
+	if err := store.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if changed := store.changed(paths); len(changed) != 0 {
+		t.Errorf("changed after reloading = %v, want none", changed)
+	}
+
+	// a broken file is only reported once
+	write(`{`)
+	if err := store.Reload(); err == nil {
+		t.Fatal("Reload of a broken file succeeded")
+	}
+	if changed := store.changed(paths); len(changed) != 0 {
+		t.Errorf("changed after a failed reload = %v, want none", changed)
+	}
+	if got := store.Rules().Sources()[0].Rules; !slices.Equal(got, []string{"after"}) {
+		t.Errorf("rules after a failed reload = %v, want [after]", got)
+	}
+}
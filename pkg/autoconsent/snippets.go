@@ -0,0 +1,135 @@
+package autoconsent
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// snippets holds the eval snippets rules can refer to, keyed by id and already normalized
+// into expressions. It starts out with JSEvals.
+var snippets = struct {
+	mu   sync.RWMutex
+	byID map[string]string
+}{byID: map[string]string{}}
+
+func init() {
+	for id, snippet := range JSEvals {
+		if err := RegisterEval(id, snippet); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// RegisterEval makes snippet available to eval steps under id, replacing any snippet already
+// registered with it. A snippet is either a JavaScript expression, or a function body that
+// returns its result, in which case it is either wrapped in braces or starts with return.
+// Rules are checked against the snippets registered when they are loaded, so snippets they
+// depend on must be registered first.
+func RegisterEval(id, snippet string) error {
+	if id == "" {
+		return fmt.Errorf("eval snippet id is empty")
+	}
+	expression, err := normalizeSnippet(snippet)
+	if err != nil {
+		return fmt.Errorf("eval snippet %s: %w", id, err)
+	}
+	snippets.mu.Lock()
+	defer snippets.mu.Unlock()
+	snippets.byID[id] = expression
+	return nil
+}
+
+// LoadEvals registers every snippet in a JSON file mapping eval ids to snippets.
+// Nothing is registered if any of them is invalid.
+func LoadEvals(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var file map[string]string
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("%s: decoding eval snippets: %w", path, err)
+	}
+	for id, snippet := range file {
+		if _, err := normalizeSnippet(snippet); err != nil {
+			return fmt.Errorf("%s: eval snippet %s: %w", path, id, err)
+		}
+	}
+	for id, snippet := range file {
+		RegisterEval(id, snippet)
+	}
+	return nil
+}
+
+// lookupEval returns the expression registered for id.
+func lookupEval(id string) (string, bool) {
+	snippets.mu.RLock()
+	defer snippets.mu.RUnlock()
+	expression, ok := snippets.byID[id]
+	return expression, ok
+}
+
+// normalizeSnippet turns a snippet into an expression evaluating to the snippet's result.
+// Bare expressions are returned as they are, function bodies are wrapped in a function that is called straight away.
+func normalizeSnippet(snippet string) (string, error) {
+	snippet = strings.TrimSpace(snippet)
+	switch {
+	case snippet == "":
+		return "", fmt.Errorf("snippet is empty")
+	case strings.HasPrefix(snippet, "{"):
+		return "(() => " + snippet + ")()", nil
+	case strings.HasPrefix(snippet, "return ") || strings.HasPrefix(snippet, "return;"):
+		return "(() => {" + snippet + "\n})()", nil
+	default:
+		return "(" + strings.TrimSuffix(snippet, ";") + "\n)", nil
+	}
+}
+
+// checkEvals reports every eval id referenced by the rules that has no registered snippet.
+func (r *AutoConsentRules) checkEvals() error {
+	missing := map[string][]string{}
+	for _, rule := range r.Rules {
+		for _, list := range []ActionList{rule.DetectCMP, rule.DetectPopup, rule.OptIn, rule.OptOut, rule.Test} {
+			walkActions(list, func(action Action) {
+				if eval, ok := action.(EvalAction); ok {
+					if _, ok := lookupEval(eval.Eval); !ok && !slices.Contains(missing[eval.Eval], rule.Name) {
+						missing[eval.Eval] = append(missing[eval.Eval], rule.Name)
+					}
+				}
+			})
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(missing))
+	for id := range missing {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	problems := make([]string, len(ids))
+	for i, id := range ids {
+		problems[i] = fmt.Sprintf("%s (used by %s)", id, strings.Join(missing[id], ", "))
+	}
+	return fmt.Errorf("unknown eval ids: %s", strings.Join(problems, "; "))
+}
+
+// walkActions calls fn for every step in actions, including the steps nested in if and any steps.
+func walkActions(actions ActionList, fn func(Action)) {
+	for _, action := range actions {
+		fn(action)
+		switch a := action.(type) {
+		case IfThenElseAction:
+			walkActions(ActionList{a.If}, fn)
+			walkActions(a.Then, fn)
+			walkActions(a.Else, fn)
+		case AnyAction:
+			walkActions(a.Any, fn)
+		}
+	}
+}
@@ -1,52 +1,239 @@
 package browser
 
+import "context"
+
 /*
 Page struct represents a web page.
 
 	title: the title of the page
 	content: the visible content of the page
 	url: the URL of the page
+	final_url: the URL the page ended up on after redirects
+	redirects: the redirects followed to reach final_url, in order
+	status_code: the HTTP status code of the main document
+	content_type: the MIME type of the main document
+	headers: selected response headers of the main document, keyed by lowercase name
+	consent: how the page's cookie consent banner was handled, see ConsentReport
 */
 type Page struct {
-	Title   string `json:"title"`
-	Content string `json:"content"`
-	URL     string `json:"url"`
+	Title       string            `json:"title"`
+	Content     string            `json:"content"`
+	URL         string            `json:"url"`
+	FinalURL    string            `json:"final_url"`
+	Redirects   []Redirect        `json:"redirects"`
+	StatusCode  int64             `json:"status_code"`
+	ContentType string            `json:"content_type"`
+	Headers     map[string]string `json:"headers"`
+	Consent     ConsentReport     `json:"consent"`
+}
+
+/*
+Redirect represents a single hop taken while loading a page.
+
+	url: the URL that redirected
+	status_code: the HTTP status code of the redirect, or of the document that navigated away on the client side
+*/
+type Redirect struct {
+	URL        string `json:"url"`
+	StatusCode int64  `json:"status_code"`
+}
+
+/*
+ConsentReport describes how the cookie consent banner of a page was handled.
+
+	policy: the consent policy that was applied
+	rule: the name of the autoconsent rule that matched, empty when no consent platform was detected
+	frame_url: the URL of the frame the rule matched in
+	popup_detected: whether the rule's detectPopup steps found the banner shown, rules without them always count as shown.
+	Nothing else is done about a banner that isn't shown
+	succeeded: whether every step run for the policy succeeded. For hide-only, whether the opt-out's hide
+	steps succeeded or the elements the rule detects the banner by were hidden
+	steps: the outcome of each opt-out, opt-in or, for hide-only, hide step that ran, in order
+	verified: whether the rule's test steps confirmed the opt-out, null when there was nothing to test
+	test_steps: the outcome of each test step that ran, in order
+	retried: whether the opt-out failed the first time and was run again, or succeeded but couldn't be
+	verified and had only its test steps run again
+	duration_ms: the time spent on consent handling in milliseconds
+	timed_out: whether consent handling was cut short by the request's consent budget
+*/
+type ConsentReport struct {
+	Policy        ConsentPolicy `json:"policy"`
+	Rule          string        `json:"rule"`
+	FrameURL      string        `json:"frame_url"`
+	PopupDetected bool          `json:"popup_detected"`
+	Succeeded     bool          `json:"succeeded"`
+	Steps         []ConsentStep `json:"steps"`
+	Verified      *bool         `json:"verified"`
+	TestSteps     []ConsentStep `json:"test_steps"`
+	Retried       bool          `json:"retried"`
+	DurationMs    int64         `json:"duration_ms"`
+	TimedOut      bool          `json:"timed_out"`
+}
+
+/*
+ConsentStep is the outcome of a single top level opt-out, opt-in or test step.
+
+	index: the position of the step in the rule's optOut, optIn or test list
+	type: the kind of step, such as click or waitForThenClick
+	ok: whether the step succeeded, after applying its optional and negated flags
+	error: why the step could not be checked or carried out, if known
+*/
+type ConsentStep struct {
+	Index int    `json:"index"`
+	Type  string `json:"type"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// ReportedHeaders lists the main document response headers copied into Page.Headers.
+var ReportedHeaders = []string{
+	"cache-control",
+	"content-language",
+	"content-length",
+	"content-type",
+	"etag",
+	"expires",
+	"last-modified",
+	"server",
+	"x-robots-tag",
+}
+
+/*
+PageRequest holds what every request that loads a page has in common.
+
+	url: the URL of the page to load
+	WaitOptions: how to wait for the page to be ready, see WaitOptions
+	EmulationOptions: the device and locale to load the page as, see EmulationOptions
+	timeout: the overall time allowed for the request in milliseconds, no limit when zero
+	consent: what to do about a cookie consent banner, reject when empty
+	consent_budget: the time allowed for detecting and answering the banner in milliseconds, 10000 when zero
+*/
+type PageRequest struct {
+	URL string `json:"url"`
+	WaitOptions
+	EmulationOptions
+	Timeout       uint64        `json:"timeout"`
+	Consent       ConsentPolicy `json:"consent"`
+	ConsentBudget uint64        `json:"consent_budget"`
 }
 
 /*
 GetPageRequest represents a request to get a web page.
-	title: the URL of the page to retrieve
-	wait_time: the time to wait for the page to load in milliseconds
+	PageRequest: the page to get and how to load it, see PageRequest
 */
 
 type GetPage struct {
-	URL      string `json:"url"`
-	WaitTime uint64 `json:"wait_time"`
+	PageRequest
 }
 
 /*
 GetScreenShotRequest represents a request to take a screenshot of a web page.
-	title: the URL of the page to capture
-	wait_time: the time to wait for the page to load in milliseconds
+	PageRequest: the page to capture and how to load it, see PageRequest
+	ScreenShotOptions: what to capture and how to encode it, see ScreenShotOptions
 */
 
 type GetScreenShotRequest struct {
-	URL      string `json:"url"`
-	WaitTime uint64 `json:"wait_time"`
+	PageRequest
+	ScreenShotOptions
 }
 
 /*
 GetScreenShotResponse represents a response to a request for a screenshot of a web page.
 	image: the screenshot image data
+	format: the encoding of image
+	consent: how the page's cookie consent banner was handled, see ConsentReport
 */
 
 type GetScreenShotResponse struct {
-	Image []byte `json:"image"`
+	Image   []byte        `json:"image"`
+	Format  ImageFormat   `json:"format"`
+	Consent ConsentReport `json:"consent"`
+}
+
+/*
+PrintPDFRequest represents a request to print a web page to PDF.
+	PageRequest: the page to print and how to load it, see PageRequest
+	PDFOptions: how to lay out the document, see PDFOptions
+*/
+
+type PrintPDFRequest struct {
+	PageRequest
+	PDFOptions
+}
+
+/*
+PrintPDFResponse represents a response to a request for a PDF of a web page.
+	pdf: the PDF document
+	consent: how the page's cookie consent banner was handled, see ConsentReport
+*/
+
+type PrintPDFResponse struct {
+	PDF     []byte        `json:"pdf"`
+	Consent ConsentReport `json:"consent"`
+}
+
+/*
+DebugConsentRequest represents a request to diagnose the consent handling of a web page.
+	PageRequest: the page to diagnose and how to load it, see PageRequest
+*/
+
+type DebugConsentRequest struct {
+	PageRequest
+}
+
+/*
+ConsentDiagnostics explains which consent rule handled a page and why.
+
+	url: the URL of the page
+	final_url: the URL the page ended up on after redirects
+	rules: every rule considered for a frame of the page, in the order they are tried
+	selected: the name of the rule consent handling used, empty when none was detected
+	consent: the outcome of consent handling, see ConsentReport
+	before: a screenshot of the page before consent handling
+	after: a screenshot of the page after consent handling
+*/
+type ConsentDiagnostics struct {
+	URL      string            `json:"url"`
+	FinalURL string            `json:"final_url"`
+	Rules    []RuleDiagnostics `json:"rules"`
+	Selected string            `json:"selected"`
+	Consent  ConsentReport     `json:"consent"`
+	Before   []byte            `json:"before"`
+	After    []byte            `json:"after"`
+}
+
+/*
+RuleDiagnostics is the detection result of one rule in one frame. Steps only run when the ones before allowed it.
+
+	rule: the name of the rule
+	frame_url: the URL of the frame the rule was checked in
+	main: whether the frame is the top level document
+	url_matched: whether the rule's urlPattern matches frame_url
+	run_context_matched: whether the rule's runContext allows it to run in this kind of frame
+	detect_cmp: the outcome of each detectCmp step that ran, in order
+	detected: whether detectCmp passed
+	detect_popup: the outcome of each detectPopup step that ran, in order
+	popup_detected: whether detectPopup passed
+*/
+type RuleDiagnostics struct {
+	Rule              string        `json:"rule"`
+	FrameURL          string        `json:"frame_url"`
+	Main              bool          `json:"main"`
+	URLMatched        bool          `json:"url_matched"`
+	RunContextMatched bool          `json:"run_context_matched"`
+	DetectCMP         []ConsentStep `json:"detect_cmp"`
+	Detected          bool          `json:"detected"`
+	DetectPopup       []ConsentStep `json:"detect_popup"`
+	PopupDetected     bool          `json:"popup_detected"`
 }
 
-// BrowserService defines the interface for browser operations
+// BrowserService defines the interface for browser operations.
+// Cancelling ctx aborts the operation and releases the tab it was using.
+// Failures are reported as *Error, whose Code says why the operation failed.
 type BrowserService interface {
-	Close()                                                             // closes the browser instance
-	GetPage(req GetPage) (Page, error)                                  // gets the HTML content of a page
-	ScreenShot(req GetScreenShotRequest) (GetScreenShotResponse, error) // takes a full screenshot of the current page
+	Close()                                                                                  // closes the browser instance
+	GetPage(ctx context.Context, req GetPage) (Page, error)                                  // gets the HTML content of a page
+	ScreenShot(ctx context.Context, req GetScreenShotRequest) (GetScreenShotResponse, error) // takes a screenshot of a page
+	PrintPDF(ctx context.Context, req PrintPDFRequest) (PrintPDFResponse, error)             // prints a page to PDF
+	DebugConsent(ctx context.Context, req DebugConsentRequest) (ConsentDiagnostics, error)   // explains how consent rules were matched and applied on a page
 }
@@ -2,9 +2,14 @@ package browser
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/SubhanAfz/scraper/pkg/autoconsent"
+	"github.com/chromedp/cdproto/network"
 	"github.com/chromedp/cdproto/page"
 	"github.com/chromedp/chromedp"
 )
@@ -12,12 +17,33 @@ import (
 type Chrome struct {
 	ctx    context.Context
 	cancel context.CancelFunc
+	pool   *tabPool
+	rules  *autoconsent.Store
 }
 
-func NewChrome() (*Chrome, error) {
+/*
+ChromeConfig holds the settings used to start a Chrome instance.
+
+	PoolSize: the maximum number of tabs serving requests concurrently, DefaultPoolSize when zero
+	Rules: the consent rules to apply, the embedded rules when nil
+*/
+type ChromeConfig struct {
+	PoolSize int
+	Rules    *autoconsent.Store
+}
+
+func NewChrome(config ChromeConfig) (*Chrome, error) {
+	rules := config.Rules
+	if rules == nil {
+		var err error
+		if rules, err = autoconsent.NewStore(autoconsent.StoreConfig{}); err != nil {
+			return nil, err
+		}
+	}
+
 	opts := append(chromedp.DefaultExecAllocatorOptions[:],
 		chromedp.Flag("headless", true),
-		chromedp.UserAgent("'Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/138.0.0.0 Safari/537.36'"),
+		chromedp.UserAgent(defaultUserAgent),
 	)
 
 	allocatorCtx, cancel := chromedp.NewExecAllocator(context.Background(), opts...)
@@ -34,6 +60,8 @@ func NewChrome() (*Chrome, error) {
 	return &Chrome{
 		ctx:    ctx,
 		cancel: cancel,
+		pool:   newTabPool(ctx, config.PoolSize),
+		rules:  rules,
 	}, nil
 }
 
@@ -41,67 +69,244 @@ func (c *Chrome) Close() {
 	c.cancel()
 }
 
-func (c *Chrome) ScreenShot(req GetScreenShotRequest) (GetScreenShotResponse, error) {
-	var buf []byte
+func (c *Chrome) ScreenShot(ctx context.Context, req GetScreenShotRequest) (GetScreenShotResponse, error) {
+	ctx, cancel := with_timeout(ctx, req.Timeout)
+	defer cancel()
 
-	wait := time.Duration(req.WaitTime) * time.Millisecond
-	var url string
-
-	err := chromedp.Run(c.ctx,
-		bypass_webdriver_detection(),
-		chromedp.Navigate(req.URL),
-		chromedp.Sleep(wait),
-		chromedp.Location(&url),
-	)
+	if err := req.ScreenShotOptions.Validate(); err != nil {
+		return GetScreenShotResponse{}, invalid_request(err)
+	}
+	t, rules, err := c.open_tab(ctx, req.PageRequest)
+	if err != nil {
+		return GetScreenShotResponse{}, err
+	}
+	defer t.close()
 
+	loaded, err := load_page(ctx, t, rules, req.PageRequest)
 	if err != nil {
 		return GetScreenShotResponse{}, err
 	}
-	rule := get_right_rule(c.ctx, url)
-	opt_out(c.ctx, rule)
-	err = chromedp.Run(c.ctx,
-		chromedp.FullScreenshot(&buf, 90),
-	)
+	var buf []byte
+	if err := chromedp.Run(t.ctx, capture(req.ScreenShotOptions, req.EmulationOptions.scale(), &buf)); err != nil {
+		return GetScreenShotResponse{}, request_error(ctx, t, CodeCaptureFailed, err)
+	}
 
 	return GetScreenShotResponse{
-		Image: buf,
+		Image:   buf,
+		Format:  req.ScreenShotOptions.format(),
+		Consent: loaded.consent,
 	}, nil
 }
 
-func (c *Chrome) GetPage(req GetPage) (Page, error) {
-	var content string
-	var title string
+func (c *Chrome) GetPage(ctx context.Context, req GetPage) (Page, error) {
+	ctx, cancel := with_timeout(ctx, req.Timeout)
+	defer cancel()
 
-	wait := time.Duration(req.WaitTime) * time.Millisecond
-	var url string
+	t, rules, err := c.open_tab(ctx, req.PageRequest)
+	if err != nil {
+		return Page{}, err
+	}
+	defer t.close()
 
-	err := chromedp.Run(c.ctx,
-		bypass_webdriver_detection(),
-		chromedp.Navigate(req.URL),
-		chromedp.Sleep(wait),
-		chromedp.Location(&url),
-	)
+	loaded, err := load_page(ctx, t, rules, req.PageRequest)
 	if err != nil {
 		return Page{}, err
 	}
-	rule := get_right_rule(c.ctx, url)
-	opt_out(c.ctx, rule)
-	err = chromedp.Run(c.ctx,
+	var content string
+	var title string
+	err = chromedp.Run(t.ctx,
 		get_visible_html(&content),
 		get_title(&title),
 	)
 
 	if err != nil {
-		return Page{}, err
+		return Page{}, request_error(ctx, t, CodeCaptureFailed, err)
 	}
 
-	return Page{
-		Title:   title,
-		Content: content,
-		URL:     req.URL,
+	result := Page{
+		Title:     title,
+		Content:   content,
+		URL:       req.URL,
+		FinalURL:  loaded.url,
+		Redirects: append([]Redirect{}, loaded.nav.redirects...),
+		Headers:   map[string]string{},
+		Consent:   loaded.consent,
+	}
+	if response := loaded.nav.response; response != nil {
+		result.StatusCode = response.Status
+		result.ContentType = response.MimeType
+		result.Headers = selected_headers(response.Headers)
+	}
+	return result, nil
+}
+
+func (c *Chrome) PrintPDF(ctx context.Context, req PrintPDFRequest) (PrintPDFResponse, error) {
+	ctx, cancel := with_timeout(ctx, req.Timeout)
+	defer cancel()
+
+	if err := req.PDFOptions.Validate(); err != nil {
+		return PrintPDFResponse{}, invalid_request(err)
+	}
+	t, rules, err := c.open_tab(ctx, req.PageRequest)
+	if err != nil {
+		return PrintPDFResponse{}, err
+	}
+	defer t.close()
+
+	loaded, err := load_page(ctx, t, rules, req.PageRequest)
+	if err != nil {
+		return PrintPDFResponse{}, err
+	}
+	var buf []byte
+	if err := chromedp.Run(t.ctx, print_pdf(req.PDFOptions, &buf)); err != nil {
+		return PrintPDFResponse{}, request_error(ctx, t, CodeCaptureFailed, err)
+	}
+
+	return PrintPDFResponse{
+		PDF:     buf,
+		Consent: loaded.consent,
 	}, nil
 }
 
+// DebugConsent loads the page without prehiding banners, so the first screenshot shows them,
+// diagnoses every candidate rule and then handles consent as GetPage would.
+func (c *Chrome) DebugConsent(ctx context.Context, req DebugConsentRequest) (ConsentDiagnostics, error) {
+	ctx, cancel := with_timeout(ctx, req.Timeout)
+	defer cancel()
+
+	t, rules, err := c.open_tab(ctx, req.PageRequest)
+	if err != nil {
+		return ConsentDiagnostics{}, err
+	}
+	defer t.close()
+
+	// loading with ConsentNone leaves the banners alone, they are handled below once they have been diagnosed
+	untouched := req.PageRequest
+	untouched.Consent, untouched.ConsentBudget = ConsentNone, 0
+	loaded, err := load_page(ctx, t, rules, untouched)
+	if err != nil {
+		return ConsentDiagnostics{}, err
+	}
+	var before, after []byte
+	if err := chromedp.Run(t.ctx, chromedp.FullScreenshot(&before, 90)); err != nil {
+		return ConsentDiagnostics{}, request_error(ctx, t, CodeCaptureFailed, err)
+	}
+
+	// both passes share the frames, so each out of process frame is attached to once
+	frames := list_frames(t.ctx, loaded.url)
+	diagnostics := diagnose_rules(t.ctx, rules, frames)
+	consent := handle_consent(t.ctx, rules, frames, req.Consent, req.ConsentBudget)
+	if err := chromedp.Run(t.ctx, chromedp.FullScreenshot(&after, 90)); err != nil {
+		return ConsentDiagnostics{}, request_error(ctx, t, CodeCaptureFailed, err)
+	}
+
+	return ConsentDiagnostics{
+		URL:      req.URL,
+		FinalURL: loaded.url,
+		Rules:    diagnostics,
+		Selected: consent.Rule,
+		Consent:  consent,
+		Before:   before,
+		After:    after,
+	}, nil
+}
+
+// open_tab validates the options every request shares and takes a tab from the pool for it,
+// along with the consent rules the request runs with.
+func (c *Chrome) open_tab(ctx context.Context, req PageRequest) (*tab, *autoconsent.AutoConsentRules, error) {
+	if err := req.Consent.Validate(); err != nil {
+		return nil, nil, invalid_request(err)
+	}
+	if err := req.EmulationOptions.Validate(); err != nil {
+		return nil, nil, invalid_request(err)
+	}
+	rules := c.rules.Rules()
+	t, err := c.pool.acquire(ctx)
+	if err != nil {
+		return nil, nil, request_error(ctx, nil, CodeTargetCrashed, err)
+	}
+	return t, rules, nil
+}
+
+/*
+loadedPage is a page opened by load_page.
+
+	url: the address the page ended up at
+	nav: the main document's response and the redirects that led to it
+	consent: what was done about the page's cookie consent banner
+*/
+type loadedPage struct {
+	url     string
+	nav     navigation
+	consent ConsentReport
+}
+
+// load_page sets up t as req's emulation options ask, navigates it to req.URL, waits as its wait options
+// say and then handles the page's cookie consent banner according to req.Consent within req.ConsentBudget.
+// req.Timeout is left to the caller, which has already applied it to ctx.
+// Errors are classified by the step that failed.
+func load_page(ctx context.Context, t *tab, rules *autoconsent.AutoConsentRules, req PageRequest) (loadedPage, error) {
+	var loaded loadedPage
+	if err := chromedp.Run(t.ctx, emulate(req.EmulationOptions)); err != nil {
+		return loadedPage{}, request_error(ctx, t, CodeEmulationFailed, err)
+	}
+	err := chromedp.Run(t.ctx, bypass_webdriver_detection(), inject_prehide(rules, req.URL, req.Consent))
+	if err == nil {
+		loaded.nav, err = navigate(t.ctx, req.URL, req.WaitOptions)
+	}
+	if err == nil {
+		err = chromedp.Run(t.ctx, chromedp.Location(&loaded.url))
+	}
+	if err != nil {
+		return loadedPage{}, request_error(ctx, t, CodeNavigationFailed, err)
+	}
+
+	// frames aren't attached to when consent isn't handled
+	var frames []consentFrame
+	if req.Consent != ConsentNone {
+		frames = list_frames(t.ctx, loaded.url)
+	}
+	loaded.consent = handle_consent(t.ctx, rules, frames, req.Consent, req.ConsentBudget)
+	return loaded, nil
+}
+
+// selected_headers copies the ReportedHeaders present in headers, normalising names to lowercase.
+func selected_headers(headers network.Headers) map[string]string {
+	selected := map[string]string{}
+	for name, value := range headers {
+		name = strings.ToLower(name)
+		if slices.Contains(ReportedHeaders, name) {
+			selected[name] = fmt.Sprint(value)
+		}
+	}
+	return selected
+}
+
+// with_timeout bounds ctx by a request's overall timeout in milliseconds, zero meaning no limit.
+func with_timeout(ctx context.Context, timeout uint64) (context.Context, context.CancelFunc) {
+	if timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(timeout)*time.Millisecond)
+}
+
+// request_error classifies err as code unless it already has one. chromedp only sees the tab
+// being closed underneath it, so a crash, the request timing out or the caller going away is
+// reported instead when that is why the tab closed.
+func request_error(ctx context.Context, t *tab, code ErrorCode, err error) error {
+	switch {
+	case t != nil && t.crashed.Load():
+		return &Error{Code: CodeTargetCrashed, Err: errors.New("the tab crashed")}
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return timed_out("the request timed out")
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case ErrorCodeOf(err) != "":
+		return err
+	}
+	return &Error{Code: code, Err: err}
+}
+
 func bypass_webdriver_detection() chromedp.ActionFunc {
 	return chromedp.ActionFunc(func(ctx context.Context) error {
 		_, err := page.AddScriptToEvaluateOnNewDocument(`Object.defineProperty(navigator, 'webdriver', {
@@ -158,102 +363,3 @@ func get_visible_html(visibleHTML *string) chromedp.Action {
 func get_title(title *string) chromedp.Action {
 	return chromedp.Evaluate(`document.title`, title)
 }
-
-func get_right_rule(ctx context.Context, url string) autoconsent.AutoConsentRule {
-	for _, rule := range autoconsent.Rules.Rules {
-		var rightRule bool = true
-		if len(rule.DetectCMP) == 0 {
-			rightRule = false
-		}
-		if rule.RunContext.UrlPattern != "" && !rule.RunContext.URLMatches(url) {
-			rightRule = false
-		}
-		if rule.RunContext.UrlPattern != "" && rightRule {
-		}
-		executed_right := ExecuteActions(ctx, rule.DetectCMP, ModeDetect)
-		if !executed_right {
-			rightRule = false
-		}
-		if rightRule {
-			return rule
-		}
-	}
-	return autoconsent.AutoConsentRule{}
-}
-
-func opt_out(ctx context.Context, rule autoconsent.AutoConsentRule) {
-	ExecuteActions(ctx, rule.OptOut, ModeExecute)
-}
-
-type ActionMode int
-
-const (
-	ModeDetect ActionMode = iota
-	ModeExecute
-)
-
-func ExecuteActions(ctx context.Context, actions autoconsent.ActionList, mode ActionMode) bool {
-	var executed_right = true
-	for _, action := range actions {
-		switch a := action.(type) {
-		case autoconsent.ClickAction:
-			if mode == ModeExecute {
-				if err := a.Click.Click(ctx); err != nil {
-				}
-			}
-		case autoconsent.WaitForThenClickAction:
-			if mode == ModeExecute {
-				if err := a.WaitForClick(ctx); err != nil {
-				}
-			}
-		case autoconsent.ExistsAction:
-			if mode == ModeDetect {
-				if exists, err := a.Exists.ElementExists(ctx); err != nil || !exists {
-					executed_right = false
-					continue
-				}
-			}
-		case autoconsent.VisibleAction:
-			if mode == ModeDetect {
-				if visible, err := a.Visible.ElementExists(ctx); err != nil || !visible {
-					executed_right = false
-					continue
-				}
-			}
-		case autoconsent.WaitForAction:
-			if mode == ModeDetect {
-				if exists := a.Wait(ctx); !exists {
-					executed_right = false
-					continue
-				}
-			}
-		case autoconsent.WaitForVisibleAction:
-			if mode == ModeDetect {
-				if visible := a.Wait(ctx); !visible {
-					executed_right = false
-					continue
-				}
-			}
-		case autoconsent.IfThenElseAction:
-			if mode == ModeExecute {
-				// Create a new ActionList containing the single 'if' action
-				ifActions := autoconsent.ActionList{a.If}
-				if ExecuteActions(ctx, ifActions, ModeDetect) {
-					// If condition is true, execute 'then' actions
-					ExecuteActions(ctx, a.Then, ModeExecute)
-				} else {
-					// If condition is false, execute 'else' actions (if any)
-					ExecuteActions(ctx, a.Else, ModeExecute)
-				}
-			}
-		case autoconsent.UnconditionalWaitAction:
-			a.Wait(ctx)
-		case autoconsent.EvalAction:
-			if mode == ModeExecute {
-				a.Evaluate(ctx)
-			}
-		default:
-		}
-	}
-	return executed_right
-}
@@ -0,0 +1,241 @@
+//go:build integration
+
+package browser
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/tabwriter"
+	"time"
+
+	"github.com/SubhanAfz/scraper/pkg/autoconsent"
+	"github.com/chromedp/chromedp"
+)
+
+var reportPath = flag.String("report", "", "write the conformance report as JSON to this file")
+
+/*
+fixture is a local page reproducing the DOM of a consent management platform.
+
+	cmp: the consent management platform the page imitates
+	rule: the name of the rule expected to handle it
+	url: the address the page is served under, so the rule's urlPattern applies
+	page: the HTML file in testdata/conformance
+	frame_url, frame_page: the address and file of the iframe the banner lives in, empty when it is in the page itself
+*/
+type fixture struct {
+	cmp       string
+	rule      string
+	url       string
+	page      string
+	frameURL  string
+	framePage string
+}
+
+// The rule file has no generic rules for most platforms, so their fixtures are served
+// under the address of a site whose rule targets the platform's DOM.
+var fixtures = []fixture{
+	{cmp: "OneTrust", rule: "nba.com", url: "https://www.nba.com/", page: "onetrust.html"},
+	{cmp: "Cookiebot", rule: "auto_GB_velux.co.uk_0", url: "https://www.velux.co.uk/", page: "cookiebot.html"},
+	{cmp: "Usercentrics", rule: "usercentrics-api", url: "https://usercentrics-api.example/", page: "usercentrics-api.html"},
+	{cmp: "Usercentrics", rule: "usercentrics-button", url: "https://usercentrics-button.example/", page: "usercentrics-button.html"},
+	{cmp: "TrustArc", rule: "auto_US_amsoil.com_fgr", url: "https://www.amsoil.com/", page: "trustarc.html"},
+	{cmp: "Didomi", rule: "auto_AU_euronews.com_swc", url: "https://www.euronews.com/", page: "didomi.html"},
+	{
+		cmp: "privacymanager.io", rule: "privacymanager.io", url: "https://publisher.example/", page: "privacymanager.html",
+		frameURL: "https://cmp-consent-tool.privacymanager.io/", framePage: "privacymanager-frame.html",
+	},
+}
+
+/*
+result is the outcome of handling consent on one fixture the way a request does. A stage that didn't run is left empty.
+
+	matched: the rule's urlPattern accepts the address of the document the banner is in
+	selected: the rule consent handling picked, which has to be the fixture's
+	detected: the fixture's rule was picked
+	popup: its detectPopup passed
+	opted_out: every optOut step passed
+	tested: the rule's test steps passed, empty when it has none
+	closed: detectPopup fails once the opt-out is done
+*/
+type result struct {
+	CMP      string `json:"cmp"`
+	Rule     string `json:"rule"`
+	URL      string `json:"url"`
+	Matched  string `json:"matched"`
+	Selected string `json:"selected"`
+	Detected string `json:"detected"`
+	Popup    string `json:"popup"`
+	OptedOut string `json:"opted_out"`
+	Tested   string `json:"tested"`
+	Closed   string `json:"closed"`
+	Pass     bool   `json:"pass"`
+}
+
+// TestConformance loads each fixture in headless Chrome through load_page, so the banner is prehidden,
+// every frame is searched, the rule is picked from the whole rule set and answered as a request would,
+// and checks each stage of that.
+func TestConformance(t *testing.T) {
+	rules, err := autoconsent.LoadDefault()
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := map[string]autoconsent.AutoConsentRule{}
+	for _, rule := range rules.Rules {
+		byName[rule.Name] = rule
+	}
+
+	pages := map[string]string{}
+	for _, f := range fixtures {
+		for address, page := range map[string]string{f.url: f.page, f.frameURL: f.framePage} {
+			if address == "" {
+				continue
+			}
+			u, err := url.Parse(address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pages[u.Host] = page
+		}
+	}
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, ok := pages[r.Host]
+		if !ok || r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, filepath.Join("testdata", "conformance", page))
+	}))
+	defer srv.Close()
+
+	pool := newTabPool(start_conformance_chrome(t, srv), 1)
+
+	var results []result
+	for _, f := range fixtures {
+		t.Run(f.cmp+"/"+f.rule, func(t *testing.T) {
+			rule, ok := byName[f.rule]
+			if !ok {
+				t.Skipf("no rule named %s", f.rule)
+			}
+			res := run_fixture(t, pool, rules, rule, f)
+			results = append(results, res)
+			if !res.Pass {
+				t.Errorf("%s failed: matched=%s selected=%q detected=%s popup=%s opted_out=%s tested=%s closed=%s",
+					f.rule, res.Matched, res.Selected, res.Detected, res.Popup, res.OptedOut, res.Tested, res.Closed)
+			}
+		})
+	}
+
+	write_report(t, results)
+}
+
+// start_conformance_chrome launches headless Chrome with every host resolving to srv, so fixtures
+// can be served under the real addresses the rules match.
+func start_conformance_chrome(t *testing.T, srv *httptest.Server) context.Context {
+	_, port, err := net.SplitHostPort(srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := append(chromedp.DefaultExecAllocatorOptions[:],
+		chromedp.NoSandbox,
+		chromedp.Flag("host-resolver-rules", "MAP * 127.0.0.1:"+port),
+		chromedp.Flag("ignore-certificate-errors", true),
+	)
+	allocatorCtx, cancelAllocator := chromedp.NewExecAllocator(context.Background(), opts...)
+	t.Cleanup(cancelAllocator)
+	ctx, cancel := chromedp.NewContext(allocatorCtx)
+	t.Cleanup(cancel)
+	if err := chromedp.Run(ctx); err != nil {
+		t.Fatalf("starting chrome: %v", err)
+	}
+	return ctx
+}
+
+// run_fixture loads the fixture in a tab from pool with the reject policy and reports how far consent handling got.
+func run_fixture(t *testing.T, pool *tabPool, ruleSet *autoconsent.AutoConsentRules, rule autoconsent.AutoConsentRule, f fixture) result {
+	res := result{CMP: f.cmp, Rule: f.rule, URL: f.url}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	tab, err := pool.acquire(ctx)
+	if err != nil {
+		t.Fatalf("opening a tab: %v", err)
+	}
+	defer tab.close()
+
+	req := PageRequest{URL: f.url, WaitOptions: WaitOptions{WaitUntil: WaitUntilLoad}, Consent: ConsentReject}
+	loaded, err := load_page(ctx, tab, ruleSet, req)
+	if err != nil {
+		t.Fatalf("loading %s: %v", f.url, err)
+	}
+	report := loaded.consent
+	res.Selected = report.Rule
+
+	bannerURL := f.url
+	if f.frameURL != "" {
+		bannerURL = f.frameURL
+	}
+	stage := func(field *string, ok bool) bool {
+		*field = outcome(ok)
+		return ok
+	}
+	res.Pass = stage(&res.Matched, rule.RunContext.URLMatches(bannerURL)) &&
+		stage(&res.Detected, report.Rule == f.rule && report.FrameURL == bannerURL) &&
+		stage(&res.Popup, report.PopupDetected) &&
+		stage(&res.OptedOut, report.Succeeded) &&
+		(report.Verified == nil || stage(&res.Tested, *report.Verified)) &&
+		stage(&res.Closed, !popup_shown(tab, loaded.url, bannerURL, rule))
+	return res
+}
+
+// popup_shown reports whether the rule still detects its banner in the document at bannerURL,
+// which doesn't count as shown once that document is gone.
+func popup_shown(t *tab, pageURL, bannerURL string, rule autoconsent.AutoConsentRule) bool {
+	for _, frame := range list_frames(t.ctx, pageURL) {
+		if frame.url == bannerURL && ExecuteActions(frame.ctx, rule.DetectPopup, ModeDetect) {
+			return true
+		}
+	}
+	return false
+}
+
+func outcome(ok bool) string {
+	if ok {
+		return "pass"
+	}
+	return "fail"
+}
+
+// write_report logs the results as a table and writes them to -report when it is set.
+func write_report(t *testing.T, results []result) {
+	var table strings.Builder
+	w := tabwriter.NewWriter(&table, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CMP\tRULE\tMATCHED\tSELECTED\tDETECTED\tPOPUP\tOPTED OUT\tTESTED\tCLOSED\tRESULT")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.CMP, r.Rule, r.Matched, r.Selected, r.Detected, r.Popup, r.OptedOut, r.Tested, r.Closed, outcome(r.Pass))
+	}
+	w.Flush()
+	t.Log("\n" + table.String())
+
+	if *reportPath == "" {
+		return
+	}
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(*reportPath, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,559 @@
+package browser
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/SubhanAfz/scraper/pkg/autoconsent"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	defaultConsentBudget uint64 = 10000 // milliseconds allowed for consent handling when a request doesn't set a budget
+	detectWorkers               = 8     // rules whose detection runs at the same time in one tab
+)
+
+// detection is a rule to detect in a frame, and the outcome once it is known.
+type detection struct {
+	rule  int
+	frame int
+	done  bool
+	ok    bool
+}
+
+// get_right_rule returns the first rule, in rule order, whose detectCmp steps pass in one of the frames.
+// Only rules whose urlPattern matches a frame are considered. Rules that detect with plain
+// exists/visible checks are answered for each frame by a single batched probe, the others run
+// concurrently so their waits overlap, and detection stops as soon as no earlier rule can still pass.
+func get_right_rule(ctx context.Context, ruleSet *autoconsent.AutoConsentRules, frames []consentFrame) (autoconsent.AutoConsentRule, consentFrame) {
+	rules := ruleSet.Rules
+
+	var pending []detection
+	for i, frame := range frames {
+		var candidates []int
+		for _, idx := range ruleSet.Candidates(frame.url) {
+			if len(rules[idx].DetectCMP) > 0 && rule_applies(rules[idx], frame) {
+				candidates = append(candidates, idx)
+			}
+		}
+		probed := probe_rules(frame, ruleSet, candidates)
+		for _, idx := range candidates {
+			ok, done := probed[idx]
+			pending = append(pending, detection{rule: idx, frame: i, done: done, ok: ok})
+		}
+	}
+	// rule order first, then frame order, which is the order a match is preferred in
+	slices.SortStableFunc(pending, func(a, b detection) int {
+		return a.rule - b.rule
+	})
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	finished := make(chan int)
+	slots := make(chan struct{}, detectWorkers)
+	for i := range pending {
+		if pending[i].done {
+			continue
+		}
+		go func(i int, d detection) {
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-ctx.Done():
+				return
+			}
+			// frame contexts evaluate in their own frame, so stopping early has to reach them separately
+			frameCtx, cancelFrame := context.WithCancel(frames[d.frame].ctx)
+			defer cancelFrame()
+			defer context.AfterFunc(ctx, cancelFrame)()
+			pending[i].ok = ExecuteActions(frameCtx, rules[d.rule].DetectCMP, ModeDetect)
+			select {
+			case finished <- i:
+			case <-ctx.Done():
+			}
+		}(i, pending[i])
+	}
+
+	for next := 0; next < len(pending); {
+		if !pending[next].done {
+			select {
+			case i := <-finished:
+				pending[i].done = true
+			case <-ctx.Done():
+				return autoconsent.AutoConsentRule{}, consentFrame{}
+			}
+			continue
+		}
+		if pending[next].ok {
+			return rules[pending[next].rule], frames[pending[next].frame]
+		}
+		next++
+	}
+	return autoconsent.AutoConsentRule{}, consentFrame{}
+}
+
+// diagnose_rules runs the detection of every rule the index considers for each frame, step by step,
+// in the order get_right_rule tries them, recording the outcome of each step.
+func diagnose_rules(ctx context.Context, ruleSet *autoconsent.AutoConsentRules, frames []consentFrame) []RuleDiagnostics {
+	rules := ruleSet.Rules
+
+	lookups := make([][]int, len(frames))
+	var order []int
+	for i, frame := range frames {
+		lookups[i] = ruleSet.Lookup(frame.url)
+		order = append(order, lookups[i]...)
+	}
+	slices.Sort(order)
+	order = slices.Compact(order)
+
+	var diagnostics []RuleDiagnostics
+	for _, idx := range order {
+		rule := rules[idx]
+		for i, frame := range frames {
+			if _, ok := slices.BinarySearch(lookups[i], idx); !ok {
+				continue
+			}
+			if ctx.Err() != nil {
+				return diagnostics
+			}
+			diag := RuleDiagnostics{
+				Rule:       rule.Name,
+				FrameURL:   frame.url,
+				Main:       frame.main,
+				URLMatched: rule.RunContext.URLMatches(frame.url),
+			}
+			diag.RunContextMatched = diag.URLMatched && rule_applies(rule, frame)
+			if diag.RunContextMatched && len(rule.DetectCMP) > 0 {
+				diag.DetectCMP, diag.Detected = run_steps(frame.ctx, rule.DetectCMP, ModeDetect)
+			}
+			if diag.Detected {
+				diag.DetectPopup, diag.PopupDetected = run_steps(frame.ctx, rule.DetectPopup, ModeDetect)
+			}
+			diagnostics = append(diagnostics, diag)
+		}
+	}
+	return diagnostics
+}
+
+// probe_rules answers the detection of every Probeable candidate in a single evaluation,
+// keyed by rule index. Rules missing from the result have to be detected step by step.
+func probe_rules(frame consentFrame, ruleSet *autoconsent.AutoConsentRules, candidates []int) map[int]bool {
+	rules := ruleSet.Rules
+	var indices []int
+	var lists []autoconsent.ActionList
+	for _, idx := range candidates {
+		if autoconsent.Probeable(rules[idx].DetectCMP) {
+			indices = append(indices, idx)
+			lists = append(lists, rules[idx].DetectCMP)
+		}
+	}
+	if len(lists) == 0 {
+		return nil
+	}
+
+	results, err := autoconsent.Probe(frame.ctx, lists)
+	if err != nil || len(results) != len(lists) {
+		return nil
+	}
+	probed := make(map[int]bool, len(indices))
+	for i, idx := range indices {
+		probed[idx] = results[i]
+	}
+	return probed
+}
+
+// rule_applies reports whether the rule's run context allows it to run in frame.
+func rule_applies(rule autoconsent.AutoConsentRule, frame consentFrame) bool {
+	if frame.main && !rule.RunContext.RunsInMain() {
+		return false
+	}
+	if !frame.main && !rule.RunContext.RunsInFrame() {
+		return false
+	}
+	return rule.RunContext.URLMatches(frame.url)
+}
+
+/*
+ConsentPolicy selects what is done about a cookie consent banner once one is detected.
+
+	reject: opt out of everything the banner asks for, the default
+	accept: opt in, so the page looks the way it does to a consenting user
+	hide-only: hide the banner without answering it
+	none: leave the banner alone and skip consent handling entirely
+*/
+type ConsentPolicy string
+
+const (
+	ConsentReject   ConsentPolicy = "reject"
+	ConsentAccept   ConsentPolicy = "accept"
+	ConsentHideOnly ConsentPolicy = "hide-only"
+	ConsentNone     ConsentPolicy = "none"
+)
+
+// Validate checks that the policy is known, an empty policy meaning reject.
+func (p ConsentPolicy) Validate() error {
+	switch p {
+	case "", ConsentReject, ConsentAccept, ConsentHideOnly, ConsentNone:
+		return nil
+	default:
+		return fmt.Errorf("unsupported consent policy: %s", p)
+	}
+}
+
+// run_steps runs actions like ExecuteActions and returns the outcome of each top level step that ran.
+func run_steps(ctx context.Context, actions autoconsent.ActionList, mode ActionMode) ([]ConsentStep, bool) {
+	var steps []ConsentStep
+	for i, action := range actions {
+		if ctx.Err() != nil {
+			return steps, false
+		}
+		ok, err := execute_action(ctx, action, mode)
+		step := ConsentStep{Index: i, Type: action.ActionType(), OK: ok}
+		if err != nil {
+			step.Error = err.Error()
+		}
+		steps = append(steps, step)
+		if !ok {
+			return steps, false
+		}
+	}
+	return steps, true
+}
+
+// testRetryDelay is how long opt_out waits before checking an opt-out a second time, giving the
+// page time to store the choice it was just told.
+const testRetryDelay = 500 * time.Millisecond
+
+// opt_out runs the rule's opt-out steps followed by its test steps. A failed opt-out is run once more,
+// since banners often ignore a click that lands while they are still animating in. When the opt-out
+// succeeded but couldn't be verified, only the test steps are run again: the banner is gone by then,
+// so repeating its clicks would fail. The report keeps the first attempt's steps unless the retry succeeded.
+func opt_out(ctx context.Context, rule autoconsent.AutoConsentRule, report *ConsentReport) {
+	report.Steps, report.Succeeded = run_steps(ctx, rule.OptOut, ModeExecute)
+	if !report.Succeeded && ctx.Err() == nil {
+		report.Retried = true
+		if steps, ok := run_steps(ctx, rule.OptOut, ModeExecute); ok {
+			report.Steps, report.Succeeded = steps, true
+		}
+	}
+	if !report.Succeeded || len(rule.Test) == 0 {
+		return
+	}
+
+	var verified bool
+	report.TestSteps, verified = run_steps(ctx, rule.Test, ModeDetect)
+	report.Verified = &verified
+	if verified || ctx.Err() != nil {
+		return
+	}
+	report.Retried = true
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(testRetryDelay):
+	}
+	if steps, ok := run_steps(ctx, rule.Test, ModeDetect); ok {
+		report.TestSteps, *report.Verified = steps, true
+	}
+}
+
+// handle_consent finds the consent management platform on the page and, when its banner is shown,
+// answers it as policy says, reporting what it found and did.
+// Prehidden banners stay hidden when a banner was found, so a failed opt-out doesn't
+// leak into the captured content, and are revealed again when nothing matched or nothing is shown.
+// Cosmetic rules only hide the banner, so the scroll lock it put on the page is lifted as well.
+// hide-only does the same without answering the banner: it runs only the hide steps of the rule's
+// opt-out and hides the elements the banner is detected by.
+// frames are the tab's documents as list_frames returns them.
+// Detecting and answering the banner is limited to budget milliseconds, defaultConsentBudget when zero.
+func handle_consent(ctx context.Context, ruleSet *autoconsent.AutoConsentRules, frames []consentFrame, policy ConsentPolicy, budget uint64) ConsentReport {
+	start := time.Now()
+	report := ConsentReport{Policy: policy}
+	if policy == "" {
+		report.Policy = ConsentReject
+	}
+	if report.Policy == ConsentNone {
+		return report
+	}
+	if budget == 0 {
+		budget = defaultConsentBudget
+	}
+	// the page itself is cleaned up with ctx, so that still works once the budget is spent
+	budgetCtx, cancel := context.WithTimeout(ctx, time.Duration(budget)*time.Millisecond)
+	defer cancel()
+	defer func() {
+		report.DurationMs = time.Since(start).Milliseconds()
+		report.TimedOut = budgetCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil
+	}()
+
+	limited, release := with_budget(budgetCtx, frames)
+	defer release()
+
+	rule, frame := get_right_rule(budgetCtx, ruleSet, limited)
+	if rule.Name == "" {
+		undo_prehide(frames)
+		return report
+	}
+	report.Rule = rule.Name
+	report.FrameURL = frame.url
+	report.PopupDetected = ExecuteActions(frame.ctx, rule.DetectPopup, ModeDetect)
+	if !report.PopupDetected {
+		// the platform is on the page but its banner isn't shown, because consent is already stored
+		// or the visitor's region doesn't need it, so there is nothing to click or hide
+		undo_prehide(frames)
+		return report
+	}
+
+	switch {
+	case report.Policy == ConsentAccept:
+		// the rules' test steps check for an opt-out, so an opt-in can't be verified with them
+		report.Steps, report.Succeeded = run_steps(frame.ctx, rule.OptIn, ModeExecute)
+	case report.Policy == ConsentReject:
+		opt_out(frame.ctx, rule, &report)
+	default:
+		// even a cosmetic rule's opt-out may click or run scripts, so only its hide steps are run
+		var hidden bool
+		if hides := rule.HideSteps(); len(hides) > 0 {
+			report.Steps, hidden = run_steps(frame.ctx, hides, ModeExecute)
+		}
+		report.Succeeded = hide_popup(frame.ctx, rule) || hidden
+	}
+	if rule.Cosmetic || report.Policy == ConsentHideOnly {
+		autoconsent.UnblockScroll(ctx)
+	}
+	return report
+}
+
+// undo_prehide removes the prehide stylesheet from every frame, as it is injected into the frames
+// sharing the tab's process as well as the top level document.
+func undo_prehide(frames []consentFrame) {
+	for _, frame := range frames {
+		autoconsent.UndoPrehide(frame.ctx)
+	}
+}
+
+// hide_popup hides the elements the rule detects its banner by, and reports whether anything
+// was hidden and none of them is still visible afterwards.
+func hide_popup(ctx context.Context, rule autoconsent.AutoConsentRule) bool {
+	selectors := rule.PopupSelectors()
+	hidden := 0
+	for _, selector := range selectors {
+		if n, err := selector.Hide(ctx); err == nil {
+			hidden += n
+		}
+	}
+	if hidden == 0 {
+		return false
+	}
+	for _, selector := range selectors {
+		// a selector the page can't evaluate hid nothing and can't show anything either
+		if visible, err := selector.ElementVisible(ctx, autoconsent.CheckAny); err == nil && visible {
+			return false
+		}
+	}
+	return true
+}
+
+// inject_prehide installs the prehide stylesheet of every rule that may apply to url
+// into each document the tab loads, before any of the page's own scripts run.
+// Nothing is hidden when the policy leaves banners alone.
+func inject_prehide(ruleSet *autoconsent.AutoConsentRules, url string, policy ConsentPolicy) chromedp.ActionFunc {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		if policy == ConsentNone {
+			return nil
+		}
+		var selectors []string
+		for _, idx := range ruleSet.Candidates(url) {
+			if rule := ruleSet.Rules[idx]; rule.RunContext.RunsInMain() {
+				selectors = append(selectors, rule.PrehideSelectors...)
+			}
+		}
+		if len(selectors) == 0 {
+			return nil
+		}
+		_, err := page.AddScriptToEvaluateOnNewDocument(autoconsent.PrehideScript(selectors)).Do(ctx)
+		return err
+	})
+}
+
+type ActionMode int
+
+const (
+	ModeDetect  ActionMode = iota // only evaluate conditions, steps that would change the page fail
+	ModeExecute                   // run every step, clicking and hiding elements as the rule says
+)
+
+// ExecuteActions runs the steps in order and reports whether all of them succeeded,
+// stopping at the first step that fails.
+func ExecuteActions(ctx context.Context, actions autoconsent.ActionList, mode ActionMode) bool {
+	for _, action := range actions {
+		if ctx.Err() != nil {
+			return false
+		}
+		if ok, _ := execute_action(ctx, action, mode); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// execute_action runs a single step, applying its negated and optional modifiers to the outcome.
+// The error explains why a step that failed couldn't be checked or carried out, and is nil
+// when the step succeeded or simply found nothing.
+func execute_action(ctx context.Context, action autoconsent.Action, mode ActionMode) (bool, error) {
+	var ok bool
+	var err error
+	switch a := action.(type) {
+	case autoconsent.ExistsAction:
+		ok, err = a.Exists.ElementExists(ctx)
+	case autoconsent.VisibleAction:
+		ok, err = a.Visible.ElementVisible(ctx, a.Check)
+	case autoconsent.WaitForAction:
+		ok = a.Wait(ctx)
+	case autoconsent.WaitForVisibleAction:
+		ok = a.Wait(ctx)
+	case autoconsent.ClickAction:
+		if mode == ModeExecute {
+			ok, err = a.Click.Click(ctx, a.All)
+		}
+	case autoconsent.WaitForThenClickAction:
+		if mode == ModeExecute {
+			err = a.WaitForClick(ctx)
+			ok = err == nil
+		}
+	case autoconsent.HideAction:
+		if mode == ModeExecute {
+			err = a.Apply(ctx)
+			ok = err == nil
+		}
+	case autoconsent.CookieMatchAction:
+		ok, err = a.Matches(ctx)
+	case autoconsent.EvalAction:
+		ok, err = a.Evaluate(ctx)
+	case autoconsent.IfThenElseAction:
+		if matched, _ := execute_action(ctx, a.If, mode); matched {
+			ok = ExecuteActions(ctx, a.Then, mode)
+		} else {
+			ok = ExecuteActions(ctx, a.Else, mode)
+		}
+	case autoconsent.AnyAction:
+		for _, step := range a.Any {
+			if matched, _ := execute_action(ctx, step, mode); matched {
+				ok = true
+				break
+			}
+		}
+	case autoconsent.UnconditionalWaitAction:
+		a.Wait(ctx)
+		ok = true
+	}
+
+	modifiers := action.Modifiers()
+	if modifiers.Negated {
+		ok = !ok
+	}
+	if ok || modifiers.Optional {
+		return true, nil
+	}
+	return false, err
+}
+
+/*
+consentFrame is a document consent rules can run in.
+
+	ctx: evaluates in the frame's document
+	url: the frame's URL, matched against the rules' urlPattern
+	main: whether this is the tab's top level document
+*/
+type consentFrame struct {
+	ctx  context.Context
+	url  string
+	main bool
+}
+
+// with_budget returns copies of frames whose contexts also end when budget does. The frames'
+// own contexts are left alone, since cancelling those would close the targets of out of process frames.
+func with_budget(budget context.Context, frames []consentFrame) ([]consentFrame, context.CancelFunc) {
+	limited := make([]consentFrame, len(frames))
+	cancels := make([]context.CancelFunc, len(frames))
+	for i, frame := range frames {
+		frameCtx, cancel := context.WithCancel(frame.ctx)
+		stop := context.AfterFunc(budget, cancel)
+		cancels[i] = func() {
+			stop()
+			cancel()
+		}
+		frame.ctx = frameCtx
+		limited[i] = frame
+	}
+	return limited, func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}
+}
+
+// list_frames returns the tab's top level document followed by all of its child frames.
+// Cross-origin frames that Chrome runs out of process are attached to as targets of their own,
+// so ctx must be the tab's context: the frames' targets are closed when it is cancelled.
+// Frames sharing the tab's process are reached through an isolated world, which sees their DOM
+// but not the globals of their scripts.
+func list_frames(ctx context.Context, url string) []consentFrame {
+	frames := []consentFrame{{ctx: ctx, url: url, main: true}}
+
+	outOfProcess := map[cdp.FrameID]bool{}
+	if targets, err := chromedp.Targets(ctx); err == nil {
+		browserContextID := chromedp.FromContext(ctx).BrowserContextID
+		for _, info := range targets {
+			if info.Type != "iframe" || info.BrowserContextID != browserContextID {
+				continue
+			}
+			// not cancelled here, as that would close the frame's target; it goes away with the tab
+			frameCtx, _ := chromedp.NewContext(ctx, chromedp.WithTargetID(info.TargetID))
+			if err := chromedp.Run(frameCtx); err != nil {
+				continue
+			}
+			// an out of process frame's target shares the frame's id
+			outOfProcess[cdp.FrameID(info.TargetID)] = true
+			frames = append(frames, consentFrame{ctx: frameCtx, url: info.URL})
+		}
+	}
+
+	var tree *page.FrameTree
+	err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		var err error
+		tree, err = page.GetFrameTree().Do(ctx)
+		return err
+	}))
+	if err != nil {
+		return frames
+	}
+
+	var walk func(children []*page.FrameTree)
+	walk = func(children []*page.FrameTree) {
+		for _, child := range children {
+			if !outOfProcess[child.Frame.ID] {
+				var id runtime.ExecutionContextID
+				err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+					var err error
+					id, err = page.CreateIsolatedWorld(child.Frame.ID).WithWorldName("autoconsent").WithGrantUniveralAccess(true).Do(ctx)
+					return err
+				}))
+				if err == nil {
+					frames = append(frames, consentFrame{
+						ctx: autoconsent.InExecutionContext(ctx, id),
+						url: child.Frame.URL,
+					})
+				}
+			}
+			walk(child.ChildFrames)
+		}
+	}
+	walk(tree.ChildFrames)
+	return frames
+}
@@ -0,0 +1,425 @@
+package browser
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SubhanAfz/scraper/pkg/autoconsent"
+	"github.com/chromedp/chromedp"
+)
+
+var (
+	chromeOnce sync.Once
+	chromeCtx  context.Context
+	chromeErr  error
+	stopChrome context.CancelFunc = func() {}
+)
+
+func TestMain(m *testing.M) {
+	// snippets used by the consent tests; the second check passes from its second evaluation on
+	for id, js := range map[string]string{
+		"EVAL_TEST_READY":         "window.testCmp.ready",
+		"EVAL_TEST_REMOVE_REJECT": "{ document.getElementById('reject').remove(); return true; }",
+		"EVAL_TEST_SECOND_CHECK":  "{ window.testChecks = (window.testChecks || 0) + 1; return window.testChecks > 1; }",
+	} {
+		if err := autoconsent.RegisterEval(id, js); err != nil {
+			panic(err)
+		}
+	}
+	code := m.Run()
+	stopChrome()
+	os.Exit(code)
+}
+
+// start_chrome launches the headless Chrome shared by the tests, skipping them when it can't be started.
+func start_chrome(t testing.TB) context.Context {
+	chromeOnce.Do(func() {
+		opts := append(chromedp.DefaultExecAllocatorOptions[:], chromedp.NoSandbox)
+		allocatorCtx, cancelAllocator := chromedp.NewExecAllocator(context.Background(), opts...)
+		ctx, cancel := chromedp.NewContext(allocatorCtx)
+		stopChrome = func() {
+			cancel()
+			cancelAllocator()
+		}
+		chromeCtx, chromeErr = ctx, chromedp.Run(ctx)
+	})
+	if chromeErr != nil {
+		t.Skipf("chrome is not available: %v", chromeErr)
+	}
+	return chromeCtx
+}
+
+// open_fixture loads testdata/actions.html in a new tab, so every case starts from a fresh page.
+func open_fixture(t testing.TB) context.Context {
+	browserCtx := start_chrome(t)
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	t.Cleanup(srv.Close)
+
+	ctx, cancel := chromedp.NewContext(browserCtx)
+	t.Cleanup(cancel)
+	ctx, cancelTimeout := context.WithTimeout(ctx, 20*time.Second)
+	t.Cleanup(cancelTimeout)
+	if err := chromedp.Run(ctx, chromedp.Navigate(srv.URL+"/actions.html")); err != nil {
+		t.Fatalf("loading the fixture: %v", err)
+	}
+	return ctx
+}
+
+func parse_steps(t testing.TB, steps string) autoconsent.ActionList {
+	var actions autoconsent.ActionList
+	if err := json.Unmarshal([]byte(steps), &actions); err != nil {
+		t.Fatalf("parsing %s: %v", steps, err)
+	}
+	return actions
+}
+
+/*
+actionCase runs steps on the fixture page.
+
+	steps: the steps as they are written in a rule
+	mode: whether steps that change the page may run
+	want: the expected outcome of ExecuteActions
+	check: JavaScript evaluated afterwards to inspect the page, skipped when empty
+	expect: the string check must evaluate to
+*/
+type actionCase struct {
+	name   string
+	steps  string
+	mode   ActionMode
+	want   bool
+	check  string
+	expect string
+}
+
+func run_cases(t *testing.T, cases []actionCase) {
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := open_fixture(t)
+			if got := ExecuteActions(ctx, parse_steps(t, c.steps), c.mode); got != c.want {
+				t.Errorf("ExecuteActions(%s) = %t, want %t", c.steps, got, c.want)
+			}
+			if c.check == "" {
+				return
+			}
+			var got string
+			if err := chromedp.Run(ctx, chromedp.Evaluate("String("+c.check+")", &got)); err != nil {
+				t.Fatalf("evaluating %s: %v", c.check, err)
+			}
+			if got != c.expect {
+				t.Errorf("%s = %q, want %q", c.check, got, c.expect)
+			}
+		})
+	}
+}
+
+const clicked = "document.body.dataset.clicked"
+
+func TestExists(t *testing.T) {
+	run_cases(t, []actionCase{
+		{name: "present", steps: `[{"exists": "#banner"}]`, want: true},
+		{name: "missing", steps: `[{"exists": "#missing"}]`, want: false},
+		{name: "text", steps: `[{"exists": ".choice", "text": "Reject"}]`, want: true},
+		{name: "regex text", steps: `[{"exists": ".choice", "text": "/^accept/i"}]`, want: true},
+		{name: "text without match", steps: `[{"exists": ".choice", "text": "Manage"}]`, want: false},
+		{name: "shadow chain", steps: `[{"exists": ["#host", "#inner"]}]`, want: true},
+		{name: "xpath", steps: `[{"exists": "xpath///button[@id='reject']"}]`, want: true},
+		{name: "hidden element", steps: `[{"exists": "#gone"}]`, want: true},
+	})
+}
+
+func TestVisible(t *testing.T) {
+	run_cases(t, []actionCase{
+		{name: "shown", steps: `[{"visible": "#banner"}]`, want: true},
+		{name: "display none", steps: `[{"visible": "#gone"}]`, want: false},
+		{name: "missing", steps: `[{"visible": "#missing"}]`, want: false},
+		{name: "all with one hidden", steps: `[{"visible": ".mixed", "check": "all"}]`, want: false},
+		{name: "any with one shown", steps: `[{"visible": ".mixed", "check": "any"}]`, want: true},
+		{name: "none with one shown", steps: `[{"visible": ".mixed", "check": "none"}]`, want: false},
+		{name: "none when hidden", steps: `[{"visible": "#gone", "check": "none"}]`, want: true},
+		{name: "none when missing", steps: `[{"visible": "#missing", "check": "none"}]`, want: true},
+	})
+}
+
+func TestWaitFor(t *testing.T) {
+	run_cases(t, []actionCase{
+		{name: "appears", steps: `[{"waitFor": "#late"}]`, want: true},
+		{name: "never appears", steps: `[{"waitFor": "#missing", "timeout": 200}]`, want: false},
+	})
+}
+
+func TestWaitForVisible(t *testing.T) {
+	run_cases(t, []actionCase{
+		{name: "becomes visible", steps: `[{"waitForVisible": "#late-visible"}]`, want: true},
+		{name: "stays hidden", steps: `[{"waitForVisible": "#gone", "timeout": 200}]`, want: false},
+	})
+}
+
+func TestClick(t *testing.T) {
+	checkedPurposes := "document.querySelectorAll('.purpose:checked').length"
+	run_cases(t, []actionCase{
+		{name: "clicks", steps: `[{"click": "#reject"}]`, mode: ModeExecute, want: true, check: clicked, expect: "reject"},
+		{name: "text", steps: `[{"click": ".choice", "text": "Accept"}]`, mode: ModeExecute, want: true, check: clicked, expect: "accept"},
+		{name: "first match only", steps: `[{"click": ".purpose"}]`, mode: ModeExecute, want: true, check: checkedPurposes, expect: "2"},
+		{name: "all matches", steps: `[{"click": ".purpose", "all": true}]`, mode: ModeExecute, want: true, check: checkedPurposes, expect: "0"},
+		{name: "missing", steps: `[{"click": "#missing"}]`, mode: ModeExecute, want: false},
+		{name: "not in detect mode", steps: `[{"click": "#reject"}]`, mode: ModeDetect, want: false, check: clicked, expect: "undefined"},
+	})
+}
+
+func TestWaitForThenClick(t *testing.T) {
+	run_cases(t, []actionCase{
+		{name: "clicks", steps: `[{"waitForThenClick": ".choice", "text": "Reject"}]`, mode: ModeExecute, want: true, check: clicked, expect: "reject"},
+		{name: "never appears", steps: `[{"waitForThenClick": "#missing", "timeout": 200}]`, mode: ModeExecute, want: false},
+		{name: "not in detect mode", steps: `[{"waitForThenClick": "#accept"}]`, mode: ModeDetect, want: false, check: clicked, expect: "undefined"},
+	})
+}
+
+func TestHide(t *testing.T) {
+	banner := "getComputedStyle(document.getElementById('banner'))"
+	run_cases(t, []actionCase{
+		{name: "display", steps: `[{"hide": "#banner"}]`, mode: ModeExecute, want: true, check: banner + ".display", expect: "none"},
+		{name: "opacity", steps: `[{"hide": "#banner", "method": "opacity"}]`, mode: ModeExecute, want: true, check: banner + ".opacity", expect: "0"},
+		{name: "not in detect mode", steps: `[{"hide": "#banner"}]`, mode: ModeDetect, want: false, check: banner + ".display", expect: "block"},
+	})
+}
+
+func TestCookieContains(t *testing.T) {
+	run_cases(t, []actionCase{
+		{name: "set", steps: `[{"cookieContains": "consent=pending"}]`, want: true},
+		{name: "not set", steps: `[{"cookieContains": "consent=given"}]`, want: false},
+	})
+}
+
+func TestEval(t *testing.T) {
+	if err := autoconsent.RegisterEval("EVAL_TEST_FALSE", "{ return false; }"); err != nil {
+		t.Fatal(err)
+	}
+	run_cases(t, []actionCase{
+		{name: "truthy", steps: `[{"eval": "EVAL_TEST_READY"}]`, want: true},
+		{name: "falsy", steps: `[{"eval": "EVAL_TEST_FALSE"}]`, want: false},
+		{name: "unknown id", steps: `[{"eval": "EVAL_TEST_UNKNOWN"}]`, want: false},
+	})
+}
+
+func TestIfThenElse(t *testing.T) {
+	run_cases(t, []actionCase{
+		{
+			name:  "then",
+			steps: `[{"if": {"exists": "#banner"}, "then": [{"click": "#reject"}], "else": [{"click": "#accept"}]}]`,
+			mode:  ModeExecute, want: true, check: clicked, expect: "reject",
+		},
+		{
+			name:  "else",
+			steps: `[{"if": {"exists": "#missing"}, "then": [{"click": "#reject"}], "else": [{"click": "#accept"}]}]`,
+			mode:  ModeExecute, want: true, check: clicked, expect: "accept",
+		},
+		{
+			name:  "failing branch",
+			steps: `[{"if": {"exists": "#banner"}, "then": [{"click": "#missing"}]}]`,
+			mode:  ModeExecute, want: false,
+		},
+	})
+}
+
+func TestAny(t *testing.T) {
+	run_cases(t, []actionCase{
+		{name: "one matches", steps: `[{"any": [{"exists": "#missing"}, {"exists": "#banner"}]}]`, want: true},
+		{name: "none match", steps: `[{"any": [{"exists": "#missing"}, {"visible": "#gone"}]}]`, want: false},
+	})
+}
+
+func TestWait(t *testing.T) {
+	ctx := open_fixture(t)
+	start := time.Now()
+	if !ExecuteActions(ctx, parse_steps(t, `[{"wait": 200}]`), ModeDetect) {
+		t.Error("wait failed")
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("wait returned after %s, want at least 200ms", elapsed)
+	}
+}
+
+func TestStepModifiers(t *testing.T) {
+	run_cases(t, []actionCase{
+		{name: "negated missing", steps: `[{"exists": "#missing", "negated": true}]`, want: true},
+		{name: "negated present", steps: `[{"exists": "#banner", "negated": true}]`, want: false},
+		{name: "optional missing", steps: `[{"exists": "#missing", "optional": true}]`, want: true},
+		{name: "optional click", steps: `[{"click": "#missing", "optional": true}, {"click": "#reject"}]`, mode: ModeExecute, want: true, check: clicked, expect: "reject"},
+		{name: "optional negated", steps: `[{"exists": "#banner", "negated": true, "optional": true}]`, want: true},
+		{name: "stops at first failure", steps: `[{"exists": "#missing"}, {"click": "#reject"}]`, mode: ModeExecute, want: false, check: clicked, expect: "undefined"},
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		ctx := open_fixture(t)
+		unknown := parse_steps(t, `[{"eval": "EVAL_TEST_UNKNOWN"}, {"eval": "EVAL_TEST_UNKNOWN", "optional": true}]`)
+		if ok, err := execute_action(ctx, unknown[0], ModeDetect); ok || err == nil {
+			t.Errorf("unknown eval = %t, %v, want false with an error", ok, err)
+		}
+		if ok, err := execute_action(ctx, unknown[1], ModeDetect); !ok || err != nil {
+			t.Errorf("optional unknown eval = %t, %v, want true without an error", ok, err)
+		}
+	})
+}
+
+// probeLists returns the detection steps of every shipped rule that applies to any page, as probe_rules batches them.
+func probeLists(b *testing.B) []autoconsent.ActionList {
+	rules, err := autoconsent.LoadDefault()
+	if err != nil {
+		b.Fatal(err)
+	}
+	var lists []autoconsent.ActionList
+	for _, idx := range rules.Candidates("https://example.org/") {
+		if autoconsent.Probeable(rules.Rules[idx].DetectCMP) {
+			lists = append(lists, rules.Rules[idx].DetectCMP)
+		}
+	}
+	return lists
+}
+
+func BenchmarkProbe(b *testing.B) {
+	ctx := open_fixture(b)
+	lists := probeLists(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := autoconsent.Probe(ctx, lists); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDetectStepByStep(b *testing.B) {
+	ctx := open_fixture(b)
+	lists := probeLists(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, steps := range lists {
+			ExecuteActions(ctx, steps, ModeDetect)
+		}
+	}
+}
+
+// load_rules parses a rule file holding rules, in the rules.json format.
+func load_rules(t testing.TB, rules string) *autoconsent.AutoConsentRules {
+	ruleSet, err := autoconsent.LoadReader(strings.NewReader(`{"autoconsent": [` + rules + `]}`))
+	if err != nil {
+		t.Fatalf("loading %s: %v", rules, err)
+	}
+	return ruleSet
+}
+
+// consent_on_fixture handles consent on the fixture page with ruleSet, returning the report and the tab.
+func consent_on_fixture(t *testing.T, ruleSet *autoconsent.AutoConsentRules, policy ConsentPolicy) (ConsentReport, context.Context) {
+	ctx := open_fixture(t)
+	var url string
+	if err := chromedp.Run(ctx, chromedp.Location(&url)); err != nil {
+		t.Fatal(err)
+	}
+	return handle_consent(ctx, ruleSet, list_frames(ctx, url), policy, 0), ctx
+}
+
+func evaluate_string(t *testing.T, ctx context.Context, js string) string {
+	var got string
+	if err := chromedp.Run(ctx, chromedp.Evaluate("String("+js+")", &got)); err != nil {
+		t.Fatalf("evaluating %s: %v", js, err)
+	}
+	return got
+}
+
+func TestHideOnlyDoesNotAnswer(t *testing.T) {
+	ruleSet := load_rules(t, `{
+		"name": "fixture-cosmetic", "cosmetic": true,
+		"detectCmp": [{"exists": "#banner"}],
+		"detectPopup": [{"visible": "#banner"}],
+		"optOut": [{"click": "#reject"}, {"if": {"exists": "#banner"}, "then": [{"hide": "#banner"}]}]
+	}`)
+	report, ctx := consent_on_fixture(t, ruleSet, ConsentHideOnly)
+	if !report.Succeeded {
+		t.Errorf("Succeeded = false, want true: %+v", report)
+	}
+	if len(report.Steps) != 1 || report.Steps[0].Type != "hide" {
+		t.Errorf("Steps = %+v, want the hide step only", report.Steps)
+	}
+	if got := evaluate_string(t, ctx, clicked); got != "undefined" {
+		t.Errorf("%s = %q, want nothing clicked", clicked, got)
+	}
+	if got := evaluate_string(t, ctx, "getComputedStyle(document.getElementById('banner')).display"); got != "none" {
+		t.Errorf("banner display = %q, want none", got)
+	}
+}
+
+func TestOptOutRetriesOnlyTheTest(t *testing.T) {
+	// the click removes the only element the opt-out can click, so a second run of it would fail
+	ruleSet := load_rules(t, `{
+		"name": "fixture-test-retry",
+		"detectCmp": [{"exists": "#banner"}],
+		"detectPopup": [{"visible": "#banner"}],
+		"optOut": [{"click": "#reject"}, {"eval": "EVAL_TEST_REMOVE_REJECT"}],
+		"test": [{"eval": "EVAL_TEST_SECOND_CHECK"}]
+	}`)
+	report, _ := consent_on_fixture(t, ruleSet, ConsentReject)
+	if !report.Succeeded || !report.Retried || report.Verified == nil || !*report.Verified {
+		t.Errorf("report = %+v, want a succeeded opt-out verified on the retry", report)
+	}
+	if len(report.Steps) != 2 || !report.Steps[0].OK {
+		t.Errorf("Steps = %+v, want the first attempt's successful steps", report.Steps)
+	}
+}
+
+func TestOptOutKeepsFirstAttempt(t *testing.T) {
+	ruleSet := load_rules(t, `{
+		"name": "fixture-failing",
+		"detectCmp": [{"exists": "#banner"}],
+		"detectPopup": [{"visible": "#banner"}],
+		"optOut": [{"click": "#reject"}, {"exists": "#missing"}],
+		"test": [{"eval": "EVAL_TEST_READY"}]
+	}`)
+	report, _ := consent_on_fixture(t, ruleSet, ConsentReject)
+	if report.Succeeded || !report.Retried || report.Verified != nil {
+		t.Errorf("report = %+v, want a failed, retried and unverified opt-out", report)
+	}
+	if len(report.Steps) != 2 || !report.Steps[0].OK || report.Steps[1].OK {
+		t.Errorf("Steps = %+v, want the click to succeed and the check to fail", report.Steps)
+	}
+}
+
+func TestUndoPrehideInFrames(t *testing.T) {
+	browserCtx := start_chrome(t)
+	srv := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	t.Cleanup(srv.Close)
+	ctx, cancel := chromedp.NewContext(browserCtx)
+	t.Cleanup(cancel)
+	ctx, cancelTimeout := context.WithTimeout(ctx, 20*time.Second)
+	t.Cleanup(cancelTimeout)
+
+	// the rule prehides the fixture's banner but is never detected, so the banner must be revealed again
+	ruleSet := load_rules(t, `{
+		"name": "fixture-absent",
+		"prehideSelectors": ["#banner"],
+		"detectCmp": [{"exists": "#missing"}],
+		"detectPopup": [{"visible": "#missing"}],
+		"optOut": [{"click": "#reject"}]
+	}`)
+	url := srv.URL + "/frames.html"
+	if err := chromedp.Run(ctx, inject_prehide(ruleSet, url, ConsentReject), chromedp.Navigate(url)); err != nil {
+		t.Fatalf("loading the fixture: %v", err)
+	}
+	const opacity = "getComputedStyle(document.getElementById('frame').contentDocument.getElementById('banner')).opacity"
+	if got := evaluate_string(t, ctx, opacity); got != "0" {
+		t.Fatalf("banner opacity before = %q, want the banner prehidden", got)
+	}
+
+	report := handle_consent(ctx, ruleSet, list_frames(ctx, url), ConsentReject, 0)
+	if report.Rule != "" {
+		t.Fatalf("Rule = %q, want none", report.Rule)
+	}
+	if got := evaluate_string(t, ctx, opacity); got != "1" {
+		t.Errorf("banner opacity after = %q, want 1", got)
+	}
+}
@@ -0,0 +1,282 @@
+package browser
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	_ "time/tzdata" // timezones are validated without relying on the host's zoneinfo
+
+	cdpbrowser "github.com/chromedp/cdproto/browser"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/chromedp"
+)
+
+// defaultUserAgent is sent when a request doesn't choose a device or user agent, so the browser
+// doesn't announce itself as HeadlessChrome.
+const defaultUserAgent = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/138.0.0.0 Safari/537.36"
+
+// languageRange is one entry of an Accept-Language value: a language tag or *, with an optional q-value.
+const languageRange = `([A-Za-z]{1,8}(-[A-Za-z0-9]{1,8})*|\*)(\s*;\s*q=(0(\.\d{0,3})?|1(\.0{0,3})?))?`
+
+var (
+	// languageTag matches a language tag such as de or zh-Hant-TW
+	languageTag = regexp.MustCompile(`^[A-Za-z]{1,8}(-[A-Za-z0-9]{1,8})*$`)
+	// acceptLanguage matches a comma separated list of language ranges
+	acceptLanguage = regexp.MustCompile(`^\s*` + languageRange + `(\s*,\s*` + languageRange + `)*\s*$`)
+)
+
+const (
+	defaultViewportWidth  int64 = 800 // headless Chrome's window size, used for the side of the viewport not set
+	defaultViewportHeight int64 = 600
+	maxTouchPoints        int64 = 5
+)
+
+/*
+Device is the screen and browser of a real device.
+
+	width, height: the size of the viewport in CSS pixels
+	scale: the device scale factor
+	mobile: lay pages out as a mobile browser does, honouring the meta viewport tag
+	touch: report a touch screen to the page
+	user_agent: the browser's user agent
+*/
+type Device struct {
+	Width     int64   `json:"width"`
+	Height    int64   `json:"height"`
+	Scale     float64 `json:"scale"`
+	Mobile    bool    `json:"mobile"`
+	Touch     bool    `json:"touch"`
+	UserAgent string  `json:"user_agent"`
+}
+
+// Devices maps the device names accepted in EmulationOptions to their presets.
+var Devices = map[string]Device{
+	"desktop": {
+		Width: 1920, Height: 1080, Scale: 1,
+		UserAgent: defaultUserAgent,
+	},
+	"iphone": {
+		Width: 393, Height: 852, Scale: 3, Mobile: true, Touch: true,
+		UserAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1",
+	},
+	"pixel": {
+		Width: 412, Height: 915, Scale: 2.625, Mobile: true, Touch: true,
+		UserAgent: "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/138.0.0.0 Mobile Safari/537.36",
+	},
+	"ipad": {
+		Width: 820, Height: 1180, Scale: 2, Mobile: true, Touch: true,
+		UserAgent: "Mozilla/5.0 (iPad; CPU OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1",
+	},
+}
+
+// ColorScheme is the value of the prefers-color-scheme media feature.
+type ColorScheme string
+
+const (
+	ColorSchemeLight ColorScheme = "light"
+	ColorSchemeDark  ColorScheme = "dark"
+)
+
+/*
+Geolocation is the position reported to pages through the Geolocation API.
+
+	latitude, longitude: the position in degrees
+	accuracy: the accuracy of the position in meters, 1 when zero
+*/
+type Geolocation struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Accuracy  float64 `json:"accuracy"`
+}
+
+/*
+EmulationOptions describes the device and locale a page is loaded as. Fields set here override the device's.
+
+	device: a preset from Devices, none when empty
+	user_agent: the user agent, the device's or a desktop Chrome one when empty
+	width, height: the size of the viewport in CSS pixels, the device's or Chrome's default when zero
+	scale: the device scale factor, the device's or 1 when zero
+	mobile: lay pages out as a mobile browser does, on as well when the device is mobile
+	touch: report a touch screen to the page, on as well when the device has one
+	accept_language: the Accept-Language header, whose first language also sets navigator.language and the locale
+	timezone: an IANA timezone such as Europe/Berlin, the host's when empty
+	geolocation: the position reported to the page, which is also granted the geolocation permission
+	color_scheme: light or dark for prefers-color-scheme, the browser's default when empty
+*/
+type EmulationOptions struct {
+	Device         string       `json:"device"`
+	UserAgent      string       `json:"user_agent"`
+	Width          int64        `json:"width"`
+	Height         int64        `json:"height"`
+	Scale          float64      `json:"scale"`
+	Mobile         bool         `json:"mobile"`
+	Touch          bool         `json:"touch"`
+	AcceptLanguage string       `json:"accept_language"`
+	Timezone       string       `json:"timezone"`
+	Geolocation    *Geolocation `json:"geolocation"`
+	ColorScheme    ColorScheme  `json:"color_scheme"`
+}
+
+// Validate checks that the device, timezone and color scheme are known, that accept_language is a
+// well formed Accept-Language value naming a language first, and that the sizes and position are in range.
+func (o EmulationOptions) Validate() error {
+	if _, ok := Devices[strings.ToLower(o.Device)]; o.Device != "" && !ok {
+		return fmt.Errorf("unsupported device: %s", o.Device)
+	}
+	if o.Width < 0 || o.Height < 0 || o.Scale < 0 {
+		return fmt.Errorf("width, height and scale must not be negative")
+	}
+	if o.AcceptLanguage != "" {
+		if !acceptLanguage.MatchString(o.AcceptLanguage) || !languageTag.MatchString(primary_language(o.AcceptLanguage)) {
+			return fmt.Errorf("invalid accept_language: %s", o.AcceptLanguage)
+		}
+	}
+	if o.Timezone != "" {
+		if _, err := time.LoadLocation(o.Timezone); err != nil {
+			return fmt.Errorf("unsupported timezone: %s", o.Timezone)
+		}
+	}
+	if g := o.Geolocation; g != nil {
+		if g.Latitude < -90 || g.Latitude > 90 || g.Longitude < -180 || g.Longitude > 180 {
+			return fmt.Errorf("latitude must be between -90 and 90 and longitude between -180 and 180")
+		}
+		if g.Accuracy < 0 {
+			return fmt.Errorf("accuracy must not be negative")
+		}
+	}
+	switch o.ColorScheme {
+	case "", ColorSchemeLight, ColorSchemeDark:
+	default:
+		return fmt.Errorf("unsupported color scheme: %s", o.ColorScheme)
+	}
+	return nil
+}
+
+// device returns the chosen device with the fields set in o applied over it.
+func (o EmulationOptions) device() Device {
+	d := Devices[strings.ToLower(o.Device)]
+	if o.Width != 0 {
+		d.Width = o.Width
+	}
+	if o.Height != 0 {
+		d.Height = o.Height
+	}
+	if o.Scale != 0 {
+		d.Scale = o.Scale
+	}
+	if o.UserAgent != "" {
+		d.UserAgent = o.UserAgent
+	}
+	d.Mobile = d.Mobile || o.Mobile
+	d.Touch = d.Touch || o.Touch
+	return d
+}
+
+// scale returns the device scale factor pages are rendered at.
+func (o EmulationOptions) scale() float64 {
+	if scale := o.device().Scale; scale != 0 {
+		return scale
+	}
+	return 1
+}
+
+// emulate applies opts to the tab before the page is loaded, so the page is requested and laid out
+// for that device and locale. Settings that aren't given are left at the browser's defaults.
+func emulate(opts EmulationOptions) chromedp.ActionFunc {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		d := opts.device()
+
+		if d.Width != 0 || d.Height != 0 || d.Scale != 0 || d.Mobile {
+			width, height, scale := d.Width, d.Height, d.Scale
+			if width == 0 {
+				width = defaultViewportWidth
+			}
+			if height == 0 {
+				height = defaultViewportHeight
+			}
+			if scale == 0 {
+				scale = 1
+			}
+			if err := emulation.SetDeviceMetricsOverride(width, height, scale, d.Mobile).Do(ctx); err != nil {
+				return err
+			}
+		}
+		if d.Touch {
+			if err := emulation.SetTouchEmulationEnabled(true).WithMaxTouchPoints(maxTouchPoints).Do(ctx); err != nil {
+				return err
+			}
+		}
+
+		if d.UserAgent != "" || opts.AcceptLanguage != "" {
+			userAgent := d.UserAgent
+			if userAgent == "" {
+				userAgent = defaultUserAgent
+			}
+			params := emulation.SetUserAgentOverride(userAgent)
+			if opts.AcceptLanguage != "" {
+				params = params.WithAcceptLanguage(opts.AcceptLanguage)
+			}
+			if err := params.Do(ctx); err != nil {
+				return err
+			}
+		}
+		if locale := primary_language(opts.AcceptLanguage); locale != "" {
+			if err := emulation.SetLocaleOverride().WithLocale(locale).Do(ctx); err != nil {
+				return fmt.Errorf("setting the locale %s: %w", locale, err)
+			}
+		}
+		if opts.Timezone != "" {
+			if err := emulation.SetTimezoneOverride(opts.Timezone).Do(ctx); err != nil {
+				return fmt.Errorf("setting the timezone %s: %w", opts.Timezone, err)
+			}
+		}
+
+		if g := opts.Geolocation; g != nil {
+			accuracy := g.Accuracy
+			if accuracy == 0 {
+				accuracy = 1
+			}
+			if err := grant_geolocation(ctx); err != nil {
+				return err
+			}
+			err := emulation.SetGeolocationOverride().
+				WithLatitude(g.Latitude).
+				WithLongitude(g.Longitude).
+				WithAccuracy(accuracy).
+				Do(ctx)
+			if err != nil {
+				return err
+			}
+		}
+		if opts.ColorScheme != "" {
+			err := emulation.SetEmulatedMedia().
+				WithFeatures([]*emulation.MediaFeature{{Name: "prefers-color-scheme", Value: string(opts.ColorScheme)}}).
+				Do(ctx)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// grant_geolocation lets every origin in the tab's browser context read the emulated position without a prompt.
+func grant_geolocation(ctx context.Context) error {
+	c := chromedp.FromContext(ctx)
+	if c == nil || c.Browser == nil {
+		return fmt.Errorf("no browser to grant the geolocation permission in")
+	}
+	return cdpbrowser.GrantPermissions([]cdpbrowser.PermissionType{cdpbrowser.PermissionTypeGeolocation}).
+		WithBrowserContextID(c.BrowserContextID).
+		Do(cdp.WithExecutor(ctx, c.Browser))
+}
+
+// primary_language returns the first language of an Accept-Language value, such as de-DE for "de-DE,de;q=0.9".
+func primary_language(acceptLanguage string) string {
+	first, _, _ := strings.Cut(acceptLanguage, ",")
+	tag, _, _ := strings.Cut(first, ";")
+	return strings.TrimSpace(tag)
+}
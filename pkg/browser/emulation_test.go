@@ -0,0 +1,27 @@
+package browser
+
+import "testing"
+
+func TestEmulationOptionsAcceptLanguage(t *testing.T) {
+	cases := []struct {
+		acceptLanguage string
+		valid          bool
+	}{
+		{"", true},
+		{"de-DE", true},
+		{"de-DE,de;q=0.9,en;q=0.8", true},
+		{"zh-Hant-TW, en ; q=0.5, *;q=0.1", true},
+		{"*", false},
+		{"*, de", false},
+		{"en_US", false},
+		{"de;q=2", false},
+		{"de,,en", false},
+		{"de\r\nX-Injected: 1", false},
+	}
+	for _, c := range cases {
+		err := EmulationOptions{AcceptLanguage: c.acceptLanguage}.Validate()
+		if (err == nil) != c.valid {
+			t.Errorf("Validate(accept_language %q) = %v, want valid %t", c.acceptLanguage, err, c.valid)
+		}
+	}
+}
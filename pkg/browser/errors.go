@@ -0,0 +1,58 @@
+package browser
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorCode is a machine readable reason a BrowserService request failed.
+type ErrorCode string
+
+const (
+	CodeInvalidRequest   ErrorCode = "invalid_request"   // the request's options are invalid
+	CodeNavigationFailed ErrorCode = "navigation_failed" // the page could not be loaded
+	CodeTimeout          ErrorCode = "timeout"           // the request or its wait condition ran out of time
+	CodeTargetCrashed    ErrorCode = "target_crashed"    // the tab or the browser crashed, or no tab could be opened
+	CodeCaptureFailed    ErrorCode = "capture_failed"    // the loaded page could not be read, screenshotted or printed
+	CodeElementNotFound  ErrorCode = "element_not_found" // the screenshot's selector matches no element with a size
+	CodePageTooLarge     ErrorCode = "page_too_large"    // the capture is larger than Chrome can render
+	CodeEmulationFailed  ErrorCode = "emulation_failed"  // the browser refused the requested device or locale settings
+)
+
+/*
+Error is the error returned by BrowserService methods when a request fails.
+
+	Code: why the request failed
+	Err: the underlying error
+*/
+type Error struct {
+	Code ErrorCode
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ErrorCodeOf returns the code of the Error in err's chain, or an empty code when there is none.
+func ErrorCodeOf(err error) ErrorCode {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return ""
+}
+
+// invalid_request marks err as a problem with the request's options.
+func invalid_request(err error) error {
+	return &Error{Code: CodeInvalidRequest, Err: err}
+}
+
+// timed_out reports that a request or one of its steps ran out of time.
+func timed_out(format string, args ...any) error {
+	return &Error{Code: CodeTimeout, Err: fmt.Errorf(format, args...)}
+}
@@ -0,0 +1,116 @@
+package browser
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+)
+
+// PaperSizes maps the paper names accepted in PDFOptions to their width and height in inches.
+var PaperSizes = map[string][2]float64{
+	"letter":  {8.5, 11},
+	"legal":   {8.5, 14},
+	"tabloid": {11, 17},
+	"a3":      {11.69, 16.54},
+	"a4":      {8.27, 11.69},
+	"a5":      {5.83, 8.27},
+}
+
+/*
+PDFOptions describes how a page is printed to PDF. Lengths are in inches.
+
+	paper: a named paper size from PaperSizes, letter when empty and no size is set
+	paper_width, paper_height: a custom paper size, overriding paper
+	margin_top, margin_bottom, margin_left, margin_right: the page margins, Chrome's default of 0.4 when unset
+	landscape: print in landscape orientation
+	print_background: include background colors and images
+	header_template, footer_template: HTML printed at the top and bottom of every page, using Chrome's
+	date, title, url, pageNumber and totalPages classes for their values
+	page_ranges: the pages to print, such as 1-5, 8, 11-13, all when empty
+*/
+type PDFOptions struct {
+	Paper           string   `json:"paper"`
+	PaperWidth      float64  `json:"paper_width"`
+	PaperHeight     float64  `json:"paper_height"`
+	MarginTop       *float64 `json:"margin_top"`
+	MarginBottom    *float64 `json:"margin_bottom"`
+	MarginLeft      *float64 `json:"margin_left"`
+	MarginRight     *float64 `json:"margin_right"`
+	Landscape       bool     `json:"landscape"`
+	PrintBackground bool     `json:"print_background"`
+	HeaderTemplate  string   `json:"header_template"`
+	FooterTemplate  string   `json:"footer_template"`
+	PageRanges      string   `json:"page_ranges"`
+}
+
+// Validate checks that the paper is known and that no length is negative.
+func (o PDFOptions) Validate() error {
+	if _, ok := PaperSizes[strings.ToLower(o.Paper)]; o.Paper != "" && !ok {
+		return fmt.Errorf("unsupported paper size: %s", o.Paper)
+	}
+	if o.PaperWidth < 0 || o.PaperHeight < 0 {
+		return fmt.Errorf("paper width and height must not be negative")
+	}
+	for _, margin := range []*float64{o.MarginTop, o.MarginBottom, o.MarginLeft, o.MarginRight} {
+		if margin != nil && *margin < 0 {
+			return fmt.Errorf("margins must not be negative")
+		}
+	}
+	return nil
+}
+
+// print_pdf prints the page in the tab to PDF as opts describe and stores the document in res.
+func print_pdf(opts PDFOptions, res *[]byte) chromedp.ActionFunc {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		size := PaperSizes["letter"]
+		if opts.Paper != "" {
+			size = PaperSizes[strings.ToLower(opts.Paper)]
+		}
+		if opts.PaperWidth != 0 {
+			size[0] = opts.PaperWidth
+		}
+		if opts.PaperHeight != 0 {
+			size[1] = opts.PaperHeight
+		}
+
+		params := page.PrintToPDF().
+			WithPaperWidth(size[0]).
+			WithPaperHeight(size[1]).
+			WithLandscape(opts.Landscape).
+			WithPrintBackground(opts.PrintBackground).
+			WithPageRanges(opts.PageRanges)
+		if opts.MarginTop != nil {
+			params = params.WithMarginTop(*opts.MarginTop)
+		}
+		if opts.MarginBottom != nil {
+			params = params.WithMarginBottom(*opts.MarginBottom)
+		}
+		if opts.MarginLeft != nil {
+			params = params.WithMarginLeft(*opts.MarginLeft)
+		}
+		if opts.MarginRight != nil {
+			params = params.WithMarginRight(*opts.MarginRight)
+		}
+		if opts.HeaderTemplate != "" || opts.FooterTemplate != "" {
+			// an empty template would print Chrome's default one, so the missing side is left blank instead
+			header, footer := opts.HeaderTemplate, opts.FooterTemplate
+			if header == "" {
+				header = "<span></span>"
+			}
+			if footer == "" {
+				footer = "<span></span>"
+			}
+			params = params.
+				WithDisplayHeaderFooter(true).
+				WithHeaderTemplate(header).
+				WithFooterTemplate(footer)
+		}
+
+		var err error
+		*res, _, err = params.Do(ctx)
+		return err
+	})
+}
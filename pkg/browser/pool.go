@@ -0,0 +1,107 @@
+package browser
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/chromedp/cdproto/inspector"
+	"github.com/chromedp/chromedp"
+)
+
+// DefaultPoolSize is the number of tabs used when ChromeConfig.PoolSize is not set.
+const DefaultPoolSize = 4
+
+/*
+tabPool hands out isolated tabs on a shared browser.
+
+	browserCtx: the chromedp context owning the browser process
+	slots: one token per tab that may be open at the same time
+*/
+type tabPool struct {
+	browserCtx context.Context
+	slots      chan struct{}
+}
+
+/*
+tab is a single browser tab leased from a tabPool.
+
+	ctx: the chromedp context bound to the tab's target, cancelled together with the request context
+	cancel: closes the target and disposes its browser context
+	stop: detaches the tab from the request context
+	crashed: set once the tab's renderer crashes, which also closes the tab
+*/
+type tab struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	stop    func() bool
+	pool    *tabPool
+	crashed atomic.Bool
+}
+
+func newTabPool(browserCtx context.Context, size int) *tabPool {
+	if size <= 0 {
+		size = DefaultPoolSize
+	}
+	return &tabPool{
+		browserCtx: browserCtx,
+		slots:      make(chan struct{}, size),
+	}
+}
+
+// acquire blocks until a slot is free or reqCtx is done, then opens a fresh tab in its own
+// browser context so cookies, storage and cache are never shared between requests.
+// The tab is closed as soon as reqCtx is cancelled, which aborts any action running on it.
+// acquire also gives up when reqCtx is done while Chrome is still creating the tab; the tab is
+// then closed once it exists, and its slot only freed after that.
+func (p *tabPool) acquire(reqCtx context.Context) (*tab, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-reqCtx.Done():
+		return nil, reqCtx.Err()
+	}
+
+	ctx, cancel := chromedp.NewContext(p.browserCtx, chromedp.WithNewBrowserContext())
+	// the first Run attaches the context to a brand new target. It isn't cancelled with reqCtx,
+	// because chromedp can only close a target it has attached to
+	created := make(chan error, 1)
+	go func() {
+		created <- chromedp.Run(ctx)
+	}()
+	select {
+	case err := <-created:
+		if err != nil {
+			cancel()
+			<-p.slots
+			return nil, err
+		}
+	case <-reqCtx.Done():
+		go func() {
+			<-created
+			cancel()
+			<-p.slots
+		}()
+		return nil, reqCtx.Err()
+	}
+
+	t := &tab{
+		ctx:    ctx,
+		cancel: cancel,
+		stop:   context.AfterFunc(reqCtx, cancel),
+		pool:   p,
+	}
+	// a crashed renderer never answers again, so the tab is closed rather than left to time out
+	chromedp.ListenTarget(ctx, func(ev any) {
+		if _, ok := ev.(*inspector.EventTargetCrashed); ok {
+			t.crashed.Store(true)
+			go cancel()
+		}
+	})
+	return t, nil
+}
+
+// close shuts the tab down and returns its slot to the pool.
+func (t *tab) close() {
+	t.stop()
+	t.cancel()
+	<-t.pool.slots
+}
@@ -0,0 +1,62 @@
+package browser
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// count_tabs returns how many page targets the browser has open.
+func count_tabs(t *testing.T, ctx context.Context) int {
+	targets, err := chromedp.Targets(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, info := range targets {
+		if info.Type == "page" {
+			n++
+		}
+	}
+	return n
+}
+
+func TestAcquireGivesUpWithRequest(t *testing.T) {
+	browserCtx := start_chrome(t)
+	pool := newTabPool(browserCtx, 1)
+	before := count_tabs(t, browserCtx)
+
+	// the deadlines run out while the tab is being created, or before a slot is taken
+	gaveUp := 0
+	for i := 0; i < 5; i++ {
+		reqCtx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		tab, err := pool.acquire(reqCtx)
+		cancel()
+		if err == nil {
+			tab.close()
+			continue
+		}
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("acquire = %v, want the request's deadline", err)
+		}
+		gaveUp++
+	}
+	if gaveUp == 0 {
+		t.Error("acquire waited for every tab past the request's deadline")
+	}
+
+	// every tab created after its request gave up is closed and its slot freed
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	tab, err := pool.acquire(ctx)
+	if err != nil {
+		t.Fatalf("acquire after the cancelled requests = %v", err)
+	}
+	tab.close()
+	if n := count_tabs(t, browserCtx); n != before {
+		t.Errorf("%d tabs open, want %d", n, before)
+	}
+}
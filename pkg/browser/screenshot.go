@@ -0,0 +1,162 @@
+package browser
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/SubhanAfz/scraper/pkg/autoconsent"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+)
+
+// ImageFormat is the encoding of a screenshot.
+type ImageFormat string
+
+const (
+	ImageFormatJPEG ImageFormat = "jpeg"
+	ImageFormatPNG  ImageFormat = "png"
+	ImageFormatWebP ImageFormat = "webp"
+)
+
+const (
+	defaultQuality int64 = 90 // compression quality for jpeg and webp screenshots
+
+	// maxCaptureSide is the longest side in device pixels Chrome renders into a single screenshot,
+	// its GPU texture limit. Larger captures come back blank or cut off.
+	maxCaptureSide float64 = 16384
+)
+
+// ContentType returns the MIME type of images in the format.
+func (f ImageFormat) ContentType() string {
+	return "image/" + string(f)
+}
+
+/*
+Clip is a rectangle of the page in CSS pixels, relative to the top left corner of the document.
+
+	x, y: the top left corner of the rectangle
+	width, height: the size of the rectangle
+*/
+type Clip struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+/*
+ScreenShotOptions describes what a screenshot captures and how it is encoded.
+
+	format: the image format, jpeg when empty
+	quality: the compression quality from 1 to 100 for jpeg and webp, 90 when zero
+	viewport_only: capture only the visible viewport instead of the whole page
+	clip: capture only this rectangle of the page
+	selector: capture only the first element matching this CSS selector, or XPath prefixed with xpath/
+*/
+type ScreenShotOptions struct {
+	Format       ImageFormat `json:"format"`
+	Quality      int64       `json:"quality"`
+	ViewportOnly bool        `json:"viewport_only"`
+	Clip         *Clip       `json:"clip"`
+	Selector     string      `json:"selector"`
+}
+
+// Validate checks that the format is known and that the options don't contradict each other.
+func (o ScreenShotOptions) Validate() error {
+	switch o.Format {
+	case "", ImageFormatJPEG, ImageFormatPNG, ImageFormatWebP:
+	default:
+		return fmt.Errorf("unsupported image format: %s", o.Format)
+	}
+	if o.Quality < 0 || o.Quality > 100 {
+		return fmt.Errorf("quality must be between 1 and 100")
+	}
+	if o.Clip != nil && o.Selector != "" {
+		return fmt.Errorf("clip and selector can't be used together")
+	}
+	if o.Clip != nil && (o.Clip.Width <= 0 || o.Clip.Height <= 0) {
+		return fmt.Errorf("clip width and height must be positive")
+	}
+	return nil
+}
+
+// format returns the image format, defaulting to jpeg.
+func (o ScreenShotOptions) format() ImageFormat {
+	if o.Format == "" {
+		return ImageFormatJPEG
+	}
+	return o.Format
+}
+
+// capture takes the screenshot described by opts of a page rendered at the device scale factor scale,
+// and stores the encoded image in res.
+func capture(opts ScreenShotOptions, scale float64, res *[]byte) chromedp.ActionFunc {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		params := page.CaptureScreenshot().
+			WithFormat(page.CaptureScreenshotFormat(opts.format())).
+			WithFromSurface(true).
+			WithCaptureBeyondViewport(!opts.ViewportOnly)
+		if opts.format() != ImageFormatPNG {
+			quality := opts.Quality
+			if quality == 0 {
+				quality = defaultQuality
+			}
+			params = params.WithQuality(quality)
+		}
+
+		var clip *page.Viewport
+		switch {
+		case opts.Clip != nil:
+			clip = &page.Viewport{X: opts.Clip.X, Y: opts.Clip.Y, Width: opts.Clip.Width, Height: opts.Clip.Height, Scale: 1}
+		case opts.Selector != "":
+			selector := autoconsent.ElementSelector{Element: opts.Selector}
+			box, found, err := selector.Box(ctx)
+			if err != nil {
+				return err
+			}
+			if !found {
+				return &Error{Code: CodeElementNotFound, Err: fmt.Errorf("no element matches %s", opts.Selector)}
+			}
+			if box.Width == 0 || box.Height == 0 {
+				return &Error{Code: CodeElementNotFound, Err: fmt.Errorf("the element matching %s has no size", opts.Selector)}
+			}
+			clip = &page.Viewport{X: box.X, Y: box.Y, Width: box.Width, Height: box.Height, Scale: 1}
+		}
+		if clip != nil {
+			params = params.WithCaptureBeyondViewport(true).WithClip(clip)
+		}
+		if err := check_capture_size(ctx, opts, scale, clip); err != nil {
+			return err
+		}
+
+		var err error
+		*res, err = params.Do(ctx)
+		return err
+	})
+}
+
+// check_capture_size fails with CodePageTooLarge when the capture would be larger than maxCaptureSide
+// on either side. Without a clip the capture covers the whole page, or the viewport when opts.ViewportOnly is set.
+func check_capture_size(ctx context.Context, opts ScreenShotOptions, scale float64, clip *page.Viewport) error {
+	var width, height float64
+	switch {
+	case clip != nil:
+		width, height = clip.Width, clip.Height
+	case opts.ViewportOnly:
+		return nil
+	default:
+		_, _, _, _, _, content, err := page.GetLayoutMetrics().Do(ctx)
+		if err != nil {
+			return err
+		}
+		width, height = content.Width, content.Height
+	}
+
+	if width*scale > maxCaptureSide || height*scale > maxCaptureSide {
+		return &Error{
+			Code: CodePageTooLarge,
+			Err:  fmt.Errorf("the capture is %.0fx%.0f pixels, more than the %.0f pixels Chrome can render on a side", width*scale, height*scale, maxCaptureSide),
+		}
+	}
+	return nil
+}
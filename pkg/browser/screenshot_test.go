@@ -0,0 +1,16 @@
+package browser
+
+import (
+	"testing"
+
+	"github.com/chromedp/chromedp"
+)
+
+func TestCaptureSelectorMatchesNothing(t *testing.T) {
+	ctx := open_fixture(t)
+	var buf []byte
+	err := chromedp.Run(ctx, capture(ScreenShotOptions{Selector: "#missing"}, 1, &buf))
+	if code := ErrorCodeOf(err); code != CodeElementNotFound {
+		t.Errorf("capture(#missing) = %v with code %q, want %q", err, code, CodeElementNotFound)
+	}
+}
@@ -0,0 +1,303 @@
+package browser
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/SubhanAfz/scraper/pkg/autoconsent"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+)
+
+// WaitCondition selects when a page counts as ready after navigation.
+type WaitCondition string
+
+const (
+	WaitUntilSleep            WaitCondition = "sleep"            // wait for the load event, then sleep for wait_time
+	WaitUntilLoad             WaitCondition = "load"             // wait for the load event
+	WaitUntilDOMContentLoaded WaitCondition = "domcontentloaded" // wait for the DOMContentLoaded event
+	WaitUntilNetworkIdle      WaitCondition = "networkidle"      // wait for the load event and no requests in flight for idle_time
+	WaitUntilSelector         WaitCondition = "selector"         // wait for wait_selector to match an element
+	WaitUntilExpression       WaitCondition = "js"               // wait for wait_expression to evaluate truthy
+)
+
+const (
+	defaultIdleTime uint64 = 500   // milliseconds without network activity for networkidle
+	defaultMaxWait  uint64 = 10000 // milliseconds allowed for condition based waits
+	pollInterval           = 100 * time.Millisecond
+)
+
+/*
+WaitOptions describes how long to wait for a page after navigating to it.
+
+	wait_until: the condition to wait for, sleep when empty
+	wait_time: the time to sleep after the load event in milliseconds, used by sleep
+	wait_selector: a CSS selector, or an XPath prefixed with xpath/, used by selector
+	wait_expression: a JavaScript expression, used by js
+	idle_time: how long the network must be quiet in milliseconds, used by networkidle
+	max_wait: the longest time to wait for any condition other than sleep in milliseconds
+*/
+type WaitOptions struct {
+	WaitUntil      WaitCondition `json:"wait_until"`
+	WaitTime       uint64        `json:"wait_time"`
+	WaitSelector   string        `json:"wait_selector"`
+	WaitExpression string        `json:"wait_expression"`
+	IdleTime       uint64        `json:"idle_time"`
+	MaxWait        uint64        `json:"max_wait"`
+}
+
+// Validate checks that the condition is known and has the arguments it needs.
+func (w WaitOptions) Validate() error {
+	switch w.WaitUntil {
+	case "", WaitUntilSleep, WaitUntilLoad, WaitUntilDOMContentLoaded, WaitUntilNetworkIdle:
+		return nil
+	case WaitUntilSelector:
+		if w.WaitSelector == "" {
+			return fmt.Errorf("wait_selector is required when waiting for a selector")
+		}
+		return nil
+	case WaitUntilExpression:
+		if w.WaitExpression == "" {
+			return fmt.Errorf("wait_expression is required when waiting for a js expression")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported wait condition: %s", w.WaitUntil)
+	}
+}
+
+/*
+navigation describes how the main document of a tab was loaded.
+
+	redirects: every hop taken before the final document, in order
+	response: the response that served the final document, nil if none was seen
+*/
+type navigation struct {
+	redirects []Redirect
+	response  *network.Response
+}
+
+// navigate loads url in the tab behind ctx and blocks until the wait condition is met.
+func navigate(ctx context.Context, url string, opts WaitOptions) (navigation, error) {
+	if err := opts.Validate(); err != nil {
+		return navigation{}, invalid_request(err)
+	}
+
+	if opts.WaitUntil != "" && opts.WaitUntil != WaitUntilSleep {
+		maxWait := opts.MaxWait
+		if maxWait == 0 {
+			maxWait = defaultMaxWait
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(maxWait)*time.Millisecond)
+		defer cancel()
+	}
+
+	events := listen_page_events(ctx)
+	defer events.stop()
+
+	err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		_, _, errorText, _, err := page.Navigate(url).Do(ctx)
+		if err != nil {
+			return err
+		}
+		if errorText != "" {
+			return fmt.Errorf("page load error %s", errorText)
+		}
+		return nil
+	}))
+	if err != nil && ctx.Err() != nil {
+		err = wait_error(ctx, opts.WaitUntil)
+	} else if err != nil {
+		err = &Error{Code: CodeNavigationFailed, Err: err}
+	}
+	if err == nil {
+		err = wait_until(ctx, events, opts)
+	}
+	return events.navigation(), err
+}
+
+// wait_until blocks until the condition in opts is met for a navigation started with events listening.
+func wait_until(ctx context.Context, events *pageEvents, opts WaitOptions) error {
+	switch opts.WaitUntil {
+	case WaitUntilDOMContentLoaded:
+		return wait_for_signal(ctx, events.domContentLoaded, opts.WaitUntil)
+	case WaitUntilLoad:
+		return wait_for_signal(ctx, events.loaded, opts.WaitUntil)
+	case WaitUntilNetworkIdle:
+		if err := wait_for_signal(ctx, events.loaded, opts.WaitUntil); err != nil {
+			return err
+		}
+		idle := opts.IdleTime
+		if idle == 0 {
+			idle = defaultIdleTime
+		}
+		return poll(ctx, opts.WaitUntil, func(ctx context.Context) (bool, error) {
+			return events.idleFor(time.Duration(idle) * time.Millisecond), nil
+		})
+	case WaitUntilSelector:
+		if err := wait_for_signal(ctx, events.domContentLoaded, opts.WaitUntil); err != nil {
+			return err
+		}
+		selector := autoconsent.ElementSelector{Element: opts.WaitSelector}
+		return poll(ctx, opts.WaitUntil, selector.ElementExists)
+	case WaitUntilExpression:
+		if err := wait_for_signal(ctx, events.domContentLoaded, opts.WaitUntil); err != nil {
+			return err
+		}
+		return poll(ctx, opts.WaitUntil, func(ctx context.Context) (bool, error) {
+			var truthy bool
+			err := chromedp.Run(ctx, chromedp.Evaluate(fmt.Sprintf("!!(%s)", opts.WaitExpression), &truthy))
+			return truthy, err
+		})
+	default:
+		if err := wait_for_signal(ctx, events.loaded, WaitUntilLoad); err != nil {
+			return err
+		}
+		return chromedp.Run(ctx, chromedp.Sleep(time.Duration(opts.WaitTime)*time.Millisecond))
+	}
+}
+
+/*
+pageEvents tracks the main document's lifecycle and in-flight network requests.
+
+	domContentLoaded: closed once DOMContentLoaded fires
+	loaded: closed once the load event fires
+	mainFrame: the tab's top level frame, whose document requests make up the navigation
+	inflight: requests that have been sent but not finished or failed
+	lastActivity: when a request last started or ended
+	redirects: hops taken by the main document so far
+	response: the latest main document response
+*/
+type pageEvents struct {
+	domContentLoaded chan struct{}
+	loaded           chan struct{}
+	stop             context.CancelFunc
+	mainFrame        cdp.FrameID
+
+	mu           sync.Mutex
+	inflight     map[network.RequestID]struct{}
+	lastActivity time.Time
+	redirects    []Redirect
+	response     *network.Response
+}
+
+func listen_page_events(ctx context.Context) *pageEvents {
+	lctx, cancel := context.WithCancel(ctx)
+	events := &pageEvents{
+		domContentLoaded: make(chan struct{}),
+		loaded:           make(chan struct{}),
+		stop:             cancel,
+		inflight:         map[network.RequestID]struct{}{},
+		lastActivity:     time.Now(),
+	}
+	// the main frame of a page target shares the target's id
+	if c := chromedp.FromContext(ctx); c != nil && c.Target != nil {
+		events.mainFrame = cdp.FrameID(c.Target.TargetID)
+	}
+	var domOnce, loadOnce sync.Once
+
+	chromedp.ListenTarget(lctx, func(ev any) {
+		switch e := ev.(type) {
+		case *page.EventDomContentEventFired:
+			domOnce.Do(func() { close(events.domContentLoaded) })
+		case *page.EventLoadEventFired:
+			loadOnce.Do(func() { close(events.loaded) })
+		case *network.EventRequestWillBeSent:
+			events.mu.Lock()
+			events.inflight[e.RequestID] = struct{}{}
+			events.lastActivity = time.Now()
+			if e.Type == network.ResourceTypeDocument && e.FrameID == events.mainFrame {
+				events.document_requested(e)
+			}
+			events.mu.Unlock()
+		case *network.EventResponseReceived:
+			if e.Type == network.ResourceTypeDocument && e.FrameID == events.mainFrame {
+				events.mu.Lock()
+				events.response = e.Response
+				events.mu.Unlock()
+			}
+		case *network.EventLoadingFinished:
+			events.finish(e.RequestID)
+		case *network.EventLoadingFailed:
+			events.finish(e.RequestID)
+		}
+	})
+	return events
+}
+
+// document_requested records the hop that led to a new main document request,
+// either an HTTP redirect or a client side navigation away from a loaded document.
+func (e *pageEvents) document_requested(ev *network.EventRequestWillBeSent) {
+	if ev.RedirectResponse != nil {
+		e.redirects = append(e.redirects, Redirect{
+			URL:        ev.RedirectResponse.URL,
+			StatusCode: ev.RedirectResponse.Status,
+		})
+		return
+	}
+	if e.response != nil {
+		e.redirects = append(e.redirects, Redirect{
+			URL:        e.response.URL,
+			StatusCode: e.response.Status,
+		})
+		e.response = nil
+	}
+}
+
+func (e *pageEvents) navigation() navigation {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return navigation{
+		redirects: append([]Redirect(nil), e.redirects...),
+		response:  e.response,
+	}
+}
+
+func (e *pageEvents) finish(id network.RequestID) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.inflight, id)
+	e.lastActivity = time.Now()
+}
+
+// idleFor reports whether no request has been in flight for at least d.
+func (e *pageEvents) idleFor(d time.Duration) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.inflight) == 0 && time.Since(e.lastActivity) >= d
+}
+
+func wait_for_signal(ctx context.Context, signal <-chan struct{}, cond WaitCondition) error {
+	select {
+	case <-signal:
+		return nil
+	case <-ctx.Done():
+		return wait_error(ctx, cond)
+	}
+}
+
+// poll calls check every pollInterval until it reports true or ctx ends.
+func poll(ctx context.Context, cond WaitCondition, check func(ctx context.Context) (bool, error)) error {
+	for {
+		if ok, err := check(ctx); err == nil && ok {
+			return nil
+		}
+		select {
+		case <-time.After(pollInterval):
+		case <-ctx.Done():
+			return wait_error(ctx, cond)
+		}
+	}
+}
+
+func wait_error(ctx context.Context, cond WaitCondition) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return timed_out("timed out waiting for %s", cond)
+	}
+	return ctx.Err()
+}
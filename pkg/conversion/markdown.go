@@ -2,6 +2,7 @@ package conversion
 
 import (
 	"bytes"
+	"net/url"
 	"strings"
 
 	htmltomarkdown "github.com/JohannesKaufmann/html-to-markdown/v2/converter"
@@ -34,7 +35,12 @@ func NewMarkdownService() *MarkdownService {
 }
 
 func (mdservice *MarkdownService) Convert(page browser.Page) (browser.Page, error) {
-	prependedContent, err := prependHrefBaseURL(page.Content, page.URL)
+	// links are relative to the document that was loaded, which differs from the requested URL after a redirect
+	baseURL := page.FinalURL
+	if baseURL == "" {
+		baseURL = page.URL
+	}
+	prependedContent, err := prependHrefBaseURL(page.Content, baseURL)
 	if err != nil {
 		return browser.Page{}, err
 	}
@@ -49,19 +55,26 @@ func (mdservice *MarkdownService) Convert(page browser.Page) (browser.Page, erro
 	return page, nil
 }
 
+// prependHrefBaseURL resolves the relative href and src attributes in htmlString against baseURL.
+// Links to a fragment of the page itself are left alone.
 func prependHrefBaseURL(htmlString, baseURL string) (string, error) {
 	doc, err := html.Parse(strings.NewReader(htmlString))
 	if err != nil {
 		return "", err
 	}
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return "", err
+	}
 
 	var f func(*html.Node)
 	f = func(n *html.Node) {
 		if n.Type == html.ElementNode {
 			for i, a := range n.Attr {
-				if (a.Key == "href" || a.Key == "src") && strings.HasPrefix(a.Val, "/") {
-					cleanBaseURL := strings.TrimRight(baseURL, "/")
-					n.Attr[i].Val = cleanBaseURL + a.Val
+				if (a.Key == "href" || a.Key == "src") && a.Val != "" && !strings.HasPrefix(a.Val, "#") {
+					if ref, err := url.Parse(strings.TrimSpace(a.Val)); err == nil && !ref.IsAbs() {
+						n.Attr[i].Val = base.ResolveReference(ref).String()
+					}
 				}
 			}
 		}
@@ -0,0 +1,30 @@
+package conversion
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/SubhanAfz/scraper/pkg/browser"
+)
+
+func TestConvertResolvesLinksAgainstFinalURL(t *testing.T) {
+	page := browser.Page{
+		URL:      "http://example.com/old",
+		FinalURL: "https://www.example.com/news/",
+		Content:  `<p><a href="/about">About</a> <a href="story">Story</a> <a href="#top">Top</a> <a href="https://other.example/">Other</a></p>`,
+	}
+	converted, err := NewMarkdownService().Convert(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"(https://www.example.com/about)",
+		"(https://www.example.com/news/story)",
+		"(#top)",
+		"(https://other.example/)",
+	} {
+		if !strings.Contains(converted.Content, want) {
+			t.Errorf("Convert() = %q, want it to contain %s", converted.Content, want)
+		}
+	}
+}
@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
+	"github.com/SubhanAfz/scraper/pkg/autoconsent"
 	"github.com/SubhanAfz/scraper/pkg/browser"
 	"github.com/SubhanAfz/scraper/pkg/conversion"
 	"github.com/modelcontextprotocol/go-sdk/mcp"
@@ -14,48 +16,254 @@ import (
 
 type Server struct {
 	BrowserService browser.BrowserService
+	Rules          *autoconsent.Store // the consent rules the browser uses, listed by RulesHandler
 }
 
+/*
+ErrorResponse is the body of every failed request.
+
+	error: a human readable description of the failure
+	code: a machine readable reason, one of the browser.ErrorCode values, not_found, not_acceptable or internal_error
+*/
 type ErrorResponse struct {
 	Error string `json:"error"`
+	Code  string `json:"code"`
 }
 
+// Codes for failures that don't come from the browser.
+const (
+	codeNotFound      = "not_found"
+	codeNotAcceptable = "not_acceptable"
+	codeInternal      = "internal_error"
+)
+
+// errorStatus is the HTTP status reported for each browser error code.
+var errorStatus = map[browser.ErrorCode]int{
+	browser.CodeInvalidRequest:   http.StatusBadRequest,
+	browser.CodeNavigationFailed: http.StatusBadGateway,
+	browser.CodeTimeout:          http.StatusGatewayTimeout,
+	browser.CodeTargetCrashed:    http.StatusServiceUnavailable,
+	browser.CodeCaptureFailed:    http.StatusInternalServerError,
+	browser.CodeElementNotFound:  http.StatusUnprocessableEntity,
+	browser.CodePageTooLarge:     http.StatusRequestEntityTooLarge,
+	browser.CodeEmulationFailed:  http.StatusInternalServerError,
+}
+
+// writeJsonError writes err with status. The code is taken from a browser error, or
+// derived from status for errors raised by the server itself.
 func writeJsonError(w http.ResponseWriter, status int, err error) {
+	code := string(browser.ErrorCodeOf(err))
+	if code == "" {
+		switch status {
+		case http.StatusBadRequest:
+			code = string(browser.CodeInvalidRequest)
+		case http.StatusNotFound:
+			code = codeNotFound
+		case http.StatusNotAcceptable:
+			code = codeNotAcceptable
+		default:
+			code = codeInternal
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+	json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error(), Code: code})
 }
 
-func (s *Server) GetPageHandler(w http.ResponseWriter, r *http.Request) {
-	// Parse query parameters
-	url := r.URL.Query().Get("url")
-	if url == "" {
-		writeJsonError(w, http.StatusBadRequest, fmt.Errorf("url parameter is required"))
-		return
+// writeBrowserError writes an error returned by the BrowserService with the status matching its code.
+func writeBrowserError(w http.ResponseWriter, err error) {
+	status, ok := errorStatus[browser.ErrorCodeOf(err)]
+	if !ok {
+		status = http.StatusInternalServerError
 	}
+	writeJsonError(w, status, err)
+}
 
-	waitTimeStr := r.URL.Query().Get("wait_time")
-	var waitTime uint64 = 1000 // default 1 second
-	if waitTimeStr != "" {
-		if parsedWaitTime, err := strconv.ParseUint(waitTimeStr, 10, 64); err != nil {
-			writeJsonError(w, http.StatusBadRequest, fmt.Errorf("invalid wait_time parameter: %s", err.Error()))
-			return
-		} else {
-			waitTime = parsedWaitTime
+// defaultTimeout is the overall time in milliseconds a request may take when the caller doesn't set one.
+const defaultTimeout uint64 = 30000
+
+// parseUintParam reads an optional unsigned integer query parameter, falling back to def when absent.
+func parseUintParam(r *http.Request, name string, def uint64) (uint64, error) {
+	str := r.URL.Query().Get(name)
+	if str == "" {
+		return def, nil
+	}
+	parsed, err := strconv.ParseUint(str, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s parameter: %s", name, err.Error())
+	}
+	return parsed, nil
+}
+
+// parseFloatParam reads an optional floating point query parameter, falling back to def when absent.
+func parseFloatParam(r *http.Request, name string, def float64) (float64, error) {
+	str := r.URL.Query().Get(name)
+	if str == "" {
+		return def, nil
+	}
+	parsed, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s parameter: %s", name, err.Error())
+	}
+	return parsed, nil
+}
+
+// parseBoolParam reads an optional boolean query parameter, falling back to def when absent.
+func parseBoolParam(r *http.Request, name string, def bool) (bool, error) {
+	str := r.URL.Query().Get(name)
+	if str == "" {
+		return def, nil
+	}
+	parsed, err := strconv.ParseBool(str)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s parameter: %s", name, err.Error())
+	}
+	return parsed, nil
+}
+
+// parseScreenShotOptions reads the query parameters describing what a screenshot captures.
+// clip is given as x,y,width,height in CSS pixels.
+func parseScreenShotOptions(r *http.Request) (browser.ScreenShotOptions, error) {
+	opts := browser.ScreenShotOptions{
+		Format:   browser.ImageFormat(r.URL.Query().Get("format")),
+		Selector: r.URL.Query().Get("selector"),
+	}
+
+	quality, err := parseUintParam(r, "quality", 0)
+	if err != nil {
+		return browser.ScreenShotOptions{}, err
+	}
+	opts.Quality = int64(quality)
+	fullPage, err := parseBoolParam(r, "full_page", true)
+	if err != nil {
+		return browser.ScreenShotOptions{}, err
+	}
+	opts.ViewportOnly = !fullPage
+
+	if clip := r.URL.Query().Get("clip"); clip != "" {
+		var c browser.Clip
+		if _, err := fmt.Sscanf(clip, "%g,%g,%g,%g", &c.X, &c.Y, &c.Width, &c.Height); err != nil {
+			return browser.ScreenShotOptions{}, fmt.Errorf("invalid clip parameter, expected x,y,width,height: %s", err.Error())
 		}
+		opts.Clip = &c
+	}
+	return opts, opts.Validate()
+}
+
+// parseConsentPolicy reads the consent query parameter shared by the page endpoints.
+func parseConsentPolicy(r *http.Request) (browser.ConsentPolicy, error) {
+	policy := browser.ConsentPolicy(r.URL.Query().Get("consent"))
+	return policy, policy.Validate()
+}
+
+// parseEmulationOptions reads the device and locale query parameters shared by the page endpoints.
+// geolocation is given as latitude,longitude or latitude,longitude,accuracy.
+func parseEmulationOptions(r *http.Request) (browser.EmulationOptions, error) {
+	opts := browser.EmulationOptions{
+		Device:         r.URL.Query().Get("device"),
+		UserAgent:      r.URL.Query().Get("user_agent"),
+		AcceptLanguage: r.URL.Query().Get("accept_language"),
+		Timezone:       r.URL.Query().Get("timezone"),
+		ColorScheme:    browser.ColorScheme(r.URL.Query().Get("color_scheme")),
+	}
+
+	width, err := parseUintParam(r, "width", 0)
+	if err != nil {
+		return browser.EmulationOptions{}, err
+	}
+	height, err := parseUintParam(r, "height", 0)
+	if err != nil {
+		return browser.EmulationOptions{}, err
+	}
+	opts.Width, opts.Height = int64(width), int64(height)
+	if opts.Scale, err = parseFloatParam(r, "scale", 0); err != nil {
+		return browser.EmulationOptions{}, err
+	}
+	if opts.Mobile, err = parseBoolParam(r, "mobile", false); err != nil {
+		return browser.EmulationOptions{}, err
+	}
+	if opts.Touch, err = parseBoolParam(r, "touch", false); err != nil {
+		return browser.EmulationOptions{}, err
+	}
+
+	if geolocation := r.URL.Query().Get("geolocation"); geolocation != "" {
+		var g browser.Geolocation
+		parts := strings.Split(geolocation, ",")
+		targets := []*float64{&g.Latitude, &g.Longitude, &g.Accuracy}
+		if len(parts) < 2 || len(parts) > len(targets) {
+			return browser.EmulationOptions{}, fmt.Errorf("invalid geolocation parameter, expected latitude,longitude[,accuracy]")
+		}
+		for i, part := range parts {
+			if *targets[i], err = strconv.ParseFloat(strings.TrimSpace(part), 64); err != nil {
+				return browser.EmulationOptions{}, fmt.Errorf("invalid geolocation parameter: %s", err.Error())
+			}
+		}
+		opts.Geolocation = &g
+	}
+	return opts, opts.Validate()
+}
+
+// parseWaitOptions reads the wait condition query parameters shared by the page endpoints.
+func parseWaitOptions(r *http.Request) (browser.WaitOptions, error) {
+	opts := browser.WaitOptions{
+		WaitUntil:      browser.WaitCondition(r.URL.Query().Get("wait_until")),
+		WaitSelector:   r.URL.Query().Get("wait_selector"),
+		WaitExpression: r.URL.Query().Get("wait_expression"),
+	}
+
+	var err error
+	if opts.WaitTime, err = parseUintParam(r, "wait_time", 1000); err != nil { // default 1 second
+		return browser.WaitOptions{}, err
+	}
+	if opts.IdleTime, err = parseUintParam(r, "idle_time", 0); err != nil {
+		return browser.WaitOptions{}, err
+	}
+	if opts.MaxWait, err = parseUintParam(r, "max_wait", 0); err != nil {
+		return browser.WaitOptions{}, err
+	}
+	return opts, opts.Validate()
+}
+
+// parsePageParams reads the query parameters shared by every endpoint that loads a page.
+func parsePageParams(r *http.Request) (browser.PageRequest, error) {
+	params := browser.PageRequest{URL: r.URL.Query().Get("url")}
+	if params.URL == "" {
+		return browser.PageRequest{}, fmt.Errorf("url parameter is required")
+	}
+	var err error
+	if params.WaitOptions, err = parseWaitOptions(r); err != nil {
+		return browser.PageRequest{}, err
+	}
+	if params.EmulationOptions, err = parseEmulationOptions(r); err != nil {
+		return browser.PageRequest{}, err
+	}
+	if params.Timeout, err = parseUintParam(r, "timeout", defaultTimeout); err != nil {
+		return browser.PageRequest{}, err
+	}
+	if params.Consent, err = parseConsentPolicy(r); err != nil {
+		return browser.PageRequest{}, err
+	}
+	if params.ConsentBudget, err = parseUintParam(r, "consent_budget", 0); err != nil {
+		return browser.PageRequest{}, err
+	}
+	return params, nil
+}
+
+func (s *Server) GetPageHandler(w http.ResponseWriter, r *http.Request) {
+	params, err := parsePageParams(r)
+	if err != nil {
+		writeJsonError(w, http.StatusBadRequest, err)
+		return
 	}
 
 	format := r.URL.Query().Get("format")
 
 	// Create the request
-	pageReq := browser.GetPage{
-		URL:      url,
-		WaitTime: waitTime,
-	}
+	pageReq := browser.GetPage{PageRequest: params}
 
-	page, err := s.BrowserService.GetPage(pageReq)
+	page, err := s.BrowserService.GetPage(r.Context(), pageReq)
 	if err != nil {
-		writeJsonError(w, http.StatusInternalServerError, err)
+		writeBrowserError(w, err)
 		return
 	}
 
@@ -72,79 +280,328 @@ func (s *Server) GetPageHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	resp := browser.Page{
-		Title:   page.Title,
-		Content: page.Content,
-		URL:     page.URL,
-	}
-	json.NewEncoder(w).Encode(resp)
+	json.NewEncoder(w).Encode(page)
 }
 
 func (s *Server) ScreenShotHandler(w http.ResponseWriter, r *http.Request) {
-	// Parse query parameters
-	url := r.URL.Query().Get("url")
-	if url == "" {
-		writeJsonError(w, http.StatusBadRequest, fmt.Errorf("url parameter is required"))
+	// the same URL answers with JSON, an image or an error depending on Accept
+	w.Header().Add("Vary", "Accept")
+
+	params, err := parsePageParams(r)
+	if err != nil {
+		writeJsonError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	waitTimeStr := r.URL.Query().Get("wait_time")
-	var waitTime uint64 = 1000 // default 1 second
-	if waitTimeStr != "" {
-		if parsedWaitTime, err := strconv.ParseUint(waitTimeStr, 10, 64); err != nil {
-			writeJsonError(w, http.StatusBadRequest, fmt.Errorf("invalid wait_time parameter: %s", err.Error()))
+	screenShotOptions, err := parseScreenShotOptions(r)
+	if err != nil {
+		writeJsonError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	raw, err := parseBoolParam(r, "raw", false)
+	if err != nil {
+		writeJsonError(w, http.StatusBadRequest, err)
+		return
+	}
+	if format, ok := acceptedImageFormat(r); ok {
+		if format != "" && screenShotOptions.Format != "" && screenShotOptions.Format != format {
+			writeJsonError(w, http.StatusNotAcceptable, fmt.Errorf("format %s conflicts with Accept: %s", screenShotOptions.Format, format.ContentType()))
 			return
-		} else {
-			waitTime = parsedWaitTime
+		}
+		raw = true
+		if screenShotOptions.Format == "" {
+			screenShotOptions.Format = format
 		}
 	}
 
 	// Create the request
 	req := browser.GetScreenShotRequest{
-		URL:      url,
-		WaitTime: waitTime,
+		PageRequest:       params,
+		ScreenShotOptions: screenShotOptions,
 	}
 
-	resp, err := s.BrowserService.ScreenShot(req)
+	resp, err := s.BrowserService.ScreenShot(r.Context(), req)
 	if err != nil {
-		writeJsonError(w, http.StatusInternalServerError, err)
+		writeBrowserError(w, err)
 		return
 	}
 
+	if raw {
+		writeRaw(w, resp.Format.ContentType(), resp.Image)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
+// acceptedImageFormat reports whether the Accept header prefers an image to anything else, such as JSON,
+// and which format it names. The format is empty for image/*.
+func acceptedImageFormat(r *http.Request) (browser.ImageFormat, bool) {
+	switch preferredMediaType(r) {
+	case "image/jpeg":
+		return browser.ImageFormatJPEG, true
+	case "image/png":
+		return browser.ImageFormatPNG, true
+	case "image/webp":
+		return browser.ImageFormatWebP, true
+	case "image/*":
+		return "", true
+	}
+	return "", false
+}
+
+// preferredMediaType returns the media range the Accept header prefers, the first of those with the
+// highest q-value, in lower case. Ranges refused with q=0 or with an invalid q-value are skipped.
+// It returns "" when nothing is accepted.
+func preferredMediaType(r *http.Request) string {
+	preferred, best := "", 0.0
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaType, params, _ := strings.Cut(mediaRange, ";")
+			mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+			if mediaType == "" {
+				continue
+			}
+			q := 1.0
+			for _, param := range strings.Split(params, ";") {
+				name, value, _ := strings.Cut(param, "=")
+				if strings.EqualFold(strings.TrimSpace(name), "q") {
+					parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+					if err != nil || parsed < 0 || parsed > 1 {
+						parsed = 0
+					}
+					q = parsed
+				}
+			}
+			if q > best {
+				preferred, best = mediaType, q
+			}
+		}
+	}
+	return preferred
+}
+
+// writeRaw sends a capture as the response body. Every capture shows the page as it was
+// at that moment, so it is not cached.
+func writeRaw(w http.ResponseWriter, contentType string, data []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// acceptsPDF reports whether the Accept header prefers the PDF itself to anything else, such as JSON.
+func acceptsPDF(r *http.Request) bool {
+	return preferredMediaType(r) == "application/pdf"
+}
+
+// parseOptionalFloatParam reads a floating point query parameter, returning nil when absent.
+func parseOptionalFloatParam(r *http.Request, name string) (*float64, error) {
+	if r.URL.Query().Get(name) == "" {
+		return nil, nil
+	}
+	parsed, err := parseFloatParam(r, name, 0)
+	return &parsed, err
+}
+
+// parsePDFOptions reads the query parameters describing how a page is printed to PDF.
+func parsePDFOptions(r *http.Request) (browser.PDFOptions, error) {
+	opts := browser.PDFOptions{
+		Paper:          r.URL.Query().Get("paper"),
+		HeaderTemplate: r.URL.Query().Get("header_template"),
+		FooterTemplate: r.URL.Query().Get("footer_template"),
+		PageRanges:     r.URL.Query().Get("page_ranges"),
+	}
+
+	var err error
+	if opts.PaperWidth, err = parseFloatParam(r, "paper_width", 0); err != nil {
+		return browser.PDFOptions{}, err
+	}
+	if opts.PaperHeight, err = parseFloatParam(r, "paper_height", 0); err != nil {
+		return browser.PDFOptions{}, err
+	}
+	if opts.MarginTop, err = parseOptionalFloatParam(r, "margin_top"); err != nil {
+		return browser.PDFOptions{}, err
+	}
+	if opts.MarginBottom, err = parseOptionalFloatParam(r, "margin_bottom"); err != nil {
+		return browser.PDFOptions{}, err
+	}
+	if opts.MarginLeft, err = parseOptionalFloatParam(r, "margin_left"); err != nil {
+		return browser.PDFOptions{}, err
+	}
+	if opts.MarginRight, err = parseOptionalFloatParam(r, "margin_right"); err != nil {
+		return browser.PDFOptions{}, err
+	}
+	if opts.Landscape, err = parseBoolParam(r, "landscape", false); err != nil {
+		return browser.PDFOptions{}, err
+	}
+	if opts.PrintBackground, err = parseBoolParam(r, "print_background", false); err != nil {
+		return browser.PDFOptions{}, err
+	}
+	return opts, opts.Validate()
+}
+
+func (s *Server) PDFHandler(w http.ResponseWriter, r *http.Request) {
+	// the same URL answers with JSON, a PDF or an error depending on Accept
+	w.Header().Add("Vary", "Accept")
+
+	params, err := parsePageParams(r)
+	if err != nil {
+		writeJsonError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	pdfOptions, err := parsePDFOptions(r)
+	if err != nil {
+		writeJsonError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	raw, err := parseBoolParam(r, "raw", false)
+	if err != nil {
+		writeJsonError(w, http.StatusBadRequest, err)
+		return
+	}
+	raw = raw || acceptsPDF(r)
+
+	req := browser.PrintPDFRequest{
+		PageRequest: params,
+		PDFOptions:  pdfOptions,
+	}
+
+	resp, err := s.BrowserService.PrintPDF(r.Context(), req)
+	if err != nil {
+		writeBrowserError(w, err)
+		return
+	}
+
+	if raw {
+		writeRaw(w, "application/pdf", resp.PDF)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) DebugConsentHandler(w http.ResponseWriter, r *http.Request) {
+	params, err := parsePageParams(r)
+	if err != nil {
+		writeJsonError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	req := browser.DebugConsentRequest{PageRequest: params}
+
+	diagnostics, err := s.BrowserService.DebugConsent(r.Context(), req)
+	if err != nil {
+		writeBrowserError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diagnostics)
+}
+
+/*
+RulesResponse lists the consent rules in use.
+
+	count: the number of rules in use
+	sources: the rule files the rules came from, in order of precedence
+*/
+type RulesResponse struct {
+	Count   int                      `json:"count"`
+	Sources []autoconsent.RuleSource `json:"sources"`
+}
+
+func (s *Server) RulesHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Rules == nil {
+		writeJsonError(w, http.StatusNotFound, fmt.Errorf("consent rules are not available"))
+		return
+	}
+	rules := s.Rules.Rules()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RulesResponse{
+		Count:   len(rules.Rules),
+		Sources: rules.Sources(),
+	})
+}
+
 type GetPageMCPRequest struct {
-	URL string `json:"url" jsonschema:"url of the page to scrape"`
+	URL            string               `json:"url" jsonschema:"url of the page to scrape"`
+	WaitUntil      string               `json:"wait_until,omitempty" jsonschema:"condition to wait for after navigating: sleep (default), load, domcontentloaded, networkidle, selector or js"`
+	WaitTime       uint64               `json:"wait_time,omitempty" jsonschema:"milliseconds to sleep after the page loads when wait_until is sleep, defaults to 1000"`
+	WaitSelector   string               `json:"wait_selector,omitempty" jsonschema:"CSS selector, or XPath prefixed with xpath/, to wait for when wait_until is selector"`
+	WaitExpression string               `json:"wait_expression,omitempty" jsonschema:"JavaScript expression to wait for to become truthy when wait_until is js"`
+	IdleTime       uint64               `json:"idle_time,omitempty" jsonschema:"milliseconds without network requests when wait_until is networkidle, defaults to 500"`
+	MaxWait        uint64               `json:"max_wait,omitempty" jsonschema:"maximum milliseconds to wait for the condition, defaults to 10000"`
+	Consent        string               `json:"consent,omitempty" jsonschema:"what to do about a cookie consent banner: reject (default), accept, hide-only or none"`
+	ConsentBudget  uint64               `json:"consent_budget,omitempty" jsonschema:"maximum milliseconds spent detecting and answering the cookie consent banner, defaults to 10000"`
+	Device         string               `json:"device,omitempty" jsonschema:"device to load the page as: desktop, iphone, pixel or ipad"`
+	UserAgent      string               `json:"user_agent,omitempty" jsonschema:"user agent to send, overriding the device's"`
+	AcceptLanguage string               `json:"accept_language,omitempty" jsonschema:"Accept-Language header to send, such as de-DE,de;q=0.9, whose first language also sets the browser locale"`
+	Timezone       string               `json:"timezone,omitempty" jsonschema:"IANA timezone the browser reports, such as Europe/Berlin"`
+	ColorScheme    string               `json:"color_scheme,omitempty" jsonschema:"prefers-color-scheme the page sees: light or dark"`
+	Geolocation    *browser.Geolocation `json:"geolocation,omitempty" jsonschema:"position the page reads through the Geolocation API"`
 }
 
 type GetPageMCPResponse struct {
-	Title   string `json:"title" jsonschema:"title of the page"`
-	Content string `json:"content" jsonschema:"markdown content of the page"`
-	URL     string `json:"url" jsonschema:"url of the page"`
+	Title   string                `json:"title" jsonschema:"title of the page"`
+	Content string                `json:"content" jsonschema:"markdown content of the page"`
+	URL     string                `json:"url" jsonschema:"url of the page"`
+	Consent browser.ConsentReport `json:"consent" jsonschema:"how the cookie consent banner of the page was handled"`
 }
 
 func (s *Server) GetPageMCPHandler(ctx context.Context, request *mcp.CallToolRequest, input GetPageMCPRequest) (*mcp.CallToolResult, GetPageMCPResponse, error) {
 
-	pageReq := browser.GetPage{
-		URL:      input.URL,
-		WaitTime: 1000,
+	waitOptions := browser.WaitOptions{
+		WaitUntil:      browser.WaitCondition(input.WaitUntil),
+		WaitTime:       input.WaitTime,
+		WaitSelector:   input.WaitSelector,
+		WaitExpression: input.WaitExpression,
+		IdleTime:       input.IdleTime,
+		MaxWait:        input.MaxWait,
 	}
-
-	page, err := s.BrowserService.GetPage(pageReq)
-	if err != nil {
+	if waitOptions.WaitTime == 0 {
+		waitOptions.WaitTime = 1000
+	}
+	if err := waitOptions.Validate(); err != nil {
+		return nil, GetPageMCPResponse{}, err
+	}
+	consent := browser.ConsentPolicy(input.Consent)
+	if err := consent.Validate(); err != nil {
+		return nil, GetPageMCPResponse{}, err
+	}
+	emulationOptions := browser.EmulationOptions{
+		Device:         input.Device,
+		UserAgent:      input.UserAgent,
+		AcceptLanguage: input.AcceptLanguage,
+		Timezone:       input.Timezone,
+		Geolocation:    input.Geolocation,
+		ColorScheme:    browser.ColorScheme(input.ColorScheme),
+	}
+	if err := emulationOptions.Validate(); err != nil {
 		return nil, GetPageMCPResponse{}, err
 	}
 
-	r := browser.Page{
-		Title:   page.Title,
-		Content: page.Content,
-		URL:     page.URL,
+	pageReq := browser.GetPage{PageRequest: browser.PageRequest{
+		URL:              input.URL,
+		WaitOptions:      waitOptions,
+		EmulationOptions: emulationOptions,
+		Timeout:          defaultTimeout,
+		Consent:          consent,
+		ConsentBudget:    input.ConsentBudget,
+	}}
+
+	page, err := s.BrowserService.GetPage(ctx, pageReq)
+	if err != nil {
+		return nil, GetPageMCPResponse{}, err
 	}
 
 	if conversionService, exists := conversion.GetService("markdown"); exists {
-		page, err = conversionService.Convert(r)
+		page, err = conversionService.Convert(page)
 		if err != nil {
 			return nil, GetPageMCPResponse{}, err
 		}
@@ -152,6 +609,7 @@ func (s *Server) GetPageMCPHandler(ctx context.Context, request *mcp.CallToolReq
 			Title:   page.Title,
 			Content: page.Content,
 			URL:     page.URL,
+			Consent: page.Consent,
 		}
 		return nil, pageResponse, nil
 	} else {
@@ -159,3 +617,60 @@ func (s *Server) GetPageMCPHandler(ctx context.Context, request *mcp.CallToolReq
 	}
 
 }
+
+type DebugConsentMCPRequest struct {
+	URL           string `json:"url" jsonschema:"url of the page to diagnose"`
+	Consent       string `json:"consent,omitempty" jsonschema:"what to do about a cookie consent banner: reject (default), accept, hide-only or none"`
+	ConsentBudget uint64 `json:"consent_budget,omitempty" jsonschema:"maximum milliseconds spent detecting and answering the cookie consent banner, defaults to 10000"`
+}
+
+type DebugConsentMCPResponse struct {
+	URL      string                    `json:"url" jsonschema:"url of the page"`
+	FinalURL string                    `json:"final_url" jsonschema:"url the page ended up on after redirects"`
+	Rules    []browser.RuleDiagnostics `json:"rules" jsonschema:"detection result of every rule considered for a frame of the page, in the order they are tried"`
+	Selected string                    `json:"selected" jsonschema:"name of the rule used to handle the banner, empty when none was detected"`
+	Consent  browser.ConsentReport     `json:"consent" jsonschema:"how the cookie consent banner of the page was handled"`
+}
+
+// DebugConsentMCPHandler returns the diagnostics as structured content, with the screenshots
+// taken before and after consent handling attached as images.
+func (s *Server) DebugConsentMCPHandler(ctx context.Context, request *mcp.CallToolRequest, input DebugConsentMCPRequest) (*mcp.CallToolResult, DebugConsentMCPResponse, error) {
+	consent := browser.ConsentPolicy(input.Consent)
+	if err := consent.Validate(); err != nil {
+		return nil, DebugConsentMCPResponse{}, err
+	}
+
+	req := browser.DebugConsentRequest{PageRequest: browser.PageRequest{
+		URL:           input.URL,
+		WaitOptions:   browser.WaitOptions{WaitTime: 1000},
+		Timeout:       defaultTimeout,
+		Consent:       consent,
+		ConsentBudget: input.ConsentBudget,
+	}}
+
+	diagnostics, err := s.BrowserService.DebugConsent(ctx, req)
+	if err != nil {
+		return nil, DebugConsentMCPResponse{}, err
+	}
+
+	response := DebugConsentMCPResponse{
+		URL:      diagnostics.URL,
+		FinalURL: diagnostics.FinalURL,
+		Rules:    diagnostics.Rules,
+		Selected: diagnostics.Selected,
+		Consent:  diagnostics.Consent,
+	}
+	text, err := json.Marshal(response)
+	if err != nil {
+		return nil, DebugConsentMCPResponse{}, err
+	}
+
+	result := &mcp.CallToolResult{
+		Content: []mcp.Content{
+			&mcp.TextContent{Text: string(text)},
+			&mcp.ImageContent{Data: diagnostics.Before, MIMEType: "image/jpeg"},
+			&mcp.ImageContent{Data: diagnostics.After, MIMEType: "image/jpeg"},
+		},
+	}
+	return result, response, nil
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/SubhanAfz/scraper/pkg/browser"
+)
+
+func TestAcceptedImageFormat(t *testing.T) {
+	cases := []struct {
+		accept string
+		format browser.ImageFormat
+		raw    bool
+	}{
+		{"", "", false},
+		{"*/*", "", false},
+		{"image/png", browser.ImageFormatPNG, true},
+		{"image/*", "", true},
+		{"IMAGE/JPEG", browser.ImageFormatJPEG, true},
+		{"application/json, image/png;q=0.1", "", false},
+		{"image/png;q=0.1, application/json", "", false},
+		{"application/json;q=0.5, image/webp", browser.ImageFormatWebP, true},
+		{"image/png;q=0, */*", "", false},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8", "", false},
+		{"image/jpeg;q=x, image/png;q=0.5", browser.ImageFormatPNG, true},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/screenshot", nil)
+		if c.accept != "" {
+			r.Header.Set("Accept", c.accept)
+		}
+		format, raw := acceptedImageFormat(r)
+		if format != c.format || raw != c.raw {
+			t.Errorf("acceptedImageFormat(%q) = %q, %t, want %q, %t", c.accept, format, raw, c.format, c.raw)
+		}
+	}
+}
+
+func TestAcceptsPDF(t *testing.T) {
+	cases := []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"application/pdf", true},
+		{"application/json, application/pdf;q=0.2", false},
+		{"application/pdf;q=0", false},
+		{"application/json;q=0.1, application/pdf;q=0.9", true},
+		{"*/*", false},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/pdf", nil)
+		if c.accept != "" {
+			r.Header.Set("Accept", c.accept)
+		}
+		if got := acceptsPDF(r); got != c.want {
+			t.Errorf("acceptsPDF(%q) = %t, want %t", c.accept, got, c.want)
+		}
+	}
+}
+
+func TestScreenShotFormatConflictsWithAccept(t *testing.T) {
+	s := &Server{}
+	r := httptest.NewRequest("GET", "/screenshot?url=https://example.com&format=jpeg", nil)
+	r.Header.Set("Accept", "image/png")
+	w := httptest.NewRecorder()
+	s.ScreenShotHandler(w, r)
+
+	if w.Code != http.StatusNotAcceptable {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusNotAcceptable)
+	}
+	var body ErrorResponse
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Code != codeNotAcceptable {
+		t.Errorf("code = %q, want %q", body.Code, codeNotAcceptable)
+	}
+	if vary := w.Header().Get("Vary"); vary != "Accept" {
+		t.Errorf("Vary = %q, want Accept", vary)
+	}
+}
+
+func TestVaryOnError(t *testing.T) {
+	s := &Server{}
+	for _, path := range []string{"/screenshot", "/pdf"} {
+		r := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		if path == "/pdf" {
+			s.PDFHandler(w, r)
+		} else {
+			s.ScreenShotHandler(w, r)
+		}
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", path, w.Code, http.StatusBadRequest)
+		}
+		if vary := w.Header().Get("Vary"); vary != "Accept" {
+			t.Errorf("%s: Vary = %q, want Accept", path, vary)
+		}
+	}
+}